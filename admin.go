@@ -0,0 +1,143 @@
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// levelsResp is the levels returned by the admin handler.
+type levelsResp struct {
+	Level  string           `json:"level"`
+	V      int32            `json:"v"`
+	Module map[string]int32 `json:"module"`
+	// Names is Config.Names, which takes precedence over Level for the named loggers
+	// and isn't changed by the admin handler.
+	Names map[string]string `json:"names,omitempty"`
+}
+
+// levelsReq is the levels changed by the admin handler, nil field is unchanged
+// and an empty module means clear.
+type levelsReq struct {
+	Level  *string          `json:"level"`
+	V      *int32           `json:"v"`
+	Module map[string]int32 `json:"module"`
+	TTL    string           `json:"ttl"`
+}
+
+// AdminHandler returns an http.Handler to view and change the levels of the default
+// logger while logging, it's safe to mount it before Init.
+//
+//	GET returns the levels, e.g. {"level":"INFO","v":0,"module":{"dao*":2},"names":{"payment":"WARN"}}
+//	PUT or POST changes them by a JSON body of the same fields or by form values,
+//	module of form values is in the format of file=1,file2=2 and empty means clear,
+//	ttl e.g. 10m reverts the change automatically, e.g.
+//	curl -X PUT 'http://127.0.0.1:8000/debug/log?level=debug&v=2&ttl=10m'
+//
+// The level changed doesn't apply to the loggers overridden by Config.Names, which are
+// returned as names.
+func AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		std().serveAdmin(w, r)
+	})
+}
+
+// AdminHandler returns an http.Handler to view and change the levels of l, see AdminHandler.
+func (l *Logger) AdminHandler() http.Handler {
+	return http.HandlerFunc(l.serveAdmin)
+}
+
+func (l *Logger) serveAdmin(w http.ResponseWriter, r *http.Request) {
+	l = l.current()
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		req, err := parseLevelsReq(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err = l.changeLevels(req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, PUT, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s := l.levels.snapshot()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&levelsResp{Level: s.level.String(), V: s.v, Module: s.module, Names: l.c.Names})
+}
+
+func parseLevelsReq(r *http.Request) (*levelsReq, error) {
+	req := &levelsReq{}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			return nil, fmt.Errorf("log: invalid body: %v", err)
+		}
+		return req, nil
+	}
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	if vs, ok := r.Form["level"]; ok {
+		req.Level = &vs[0]
+	}
+	if vs, ok := r.Form["v"]; ok {
+		v, err := strconv.ParseInt(vs[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("log: invalid v %q", vs[0])
+		}
+		v32 := int32(v)
+		req.V = &v32
+	}
+	if vs, ok := r.Form["module"]; ok {
+		module := verboseModule{}
+		if vs[0] != "" {
+			if err := module.Set(vs[0]); err != nil {
+				return nil, err
+			}
+		}
+		req.Module = module
+	}
+	req.TTL = r.Form.Get("ttl")
+	return req, nil
+}
+
+// changeLevels validates all the fields of req before changing any level.
+func (l *Logger) changeLevels(req *levelsReq) error {
+	var (
+		ttl time.Duration
+		lv  Level
+		err error
+	)
+	if req.TTL != "" {
+		if ttl, err = time.ParseDuration(req.TTL); err != nil {
+			return fmt.Errorf("log: invalid ttl %q", req.TTL)
+		}
+	}
+	if req.Level != nil {
+		var ok bool
+		if lv, ok = parseLevel(*req.Level); !ok {
+			return fmt.Errorf("log: invalid level %q", *req.Level)
+		}
+	}
+	l.levels.change(ttl, func() {
+		if req.Level != nil {
+			l.levels.level.SetLevel(lv)
+		}
+		if req.V != nil {
+			atomic.StoreInt32(&l.levels.v, *req.V)
+		}
+		if req.Module != nil {
+			l.levels.vmodule.Store(newModuleCache(req.Module))
+		}
+	})
+	return nil
+}
@@ -0,0 +1,93 @@
+package log
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func adminDo(t *testing.T, h http.Handler, method, target, contentType, body string) (int, levelsResp) {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var resp levelsResp
+	if rec.Code == http.StatusOK {
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	}
+	return rec.Code, resp
+}
+
+func TestAdminHandler(t *testing.T) {
+	th := &testHandler{}
+	l := newLogger(&Config{V: 1}, newHandlers(nil, th))
+	h := l.AdminHandler()
+
+	code, resp := adminDo(t, h, http.MethodGet, "/", "", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, levelsResp{Level: "INFO", V: 1}, resp)
+
+	code, resp = adminDo(t, h, http.MethodPut, "/?level=debug&v=3&module=dao*=2", "", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, levelsResp{Level: "DEBUG", V: 3, Module: map[string]int32{"dao*": 2}}, resp)
+	l.Named("child").Debug("shown")
+	assert.Len(t, th.entries, 1)
+
+	code, resp = adminDo(t, h, http.MethodPost, "/", "application/json", `{"level":"warn","module":{}}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, levelsResp{Level: "WARN", V: 3, Module: map[string]int32{}}, resp)
+	l.Info("hidden")
+	assert.Len(t, th.entries, 1)
+
+	code, _ = adminDo(t, h, http.MethodPut, "/?level=verbose", "", "")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = adminDo(t, h, http.MethodPut, "/?v=x", "", "")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = adminDo(t, h, http.MethodDelete, "/", "", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, code)
+	assert.Equal(t, WarnLevel, l.Level())
+
+	// the overrides of Config.Names are returned and take precedence over level.
+	l = newLogger(&Config{Names: map[string]string{"payment": "ERROR"}}, newHandlers(nil, th))
+	code, resp = adminDo(t, l.AdminHandler(), http.MethodPut, "/?level=debug", "", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, levelsResp{Level: "DEBUG", Names: map[string]string{"payment": "ERROR"}}, resp)
+	l.Named("payment").Warn("hidden")
+	assert.Len(t, th.entries, 1)
+}
+
+func TestAdminHandlerTTL(t *testing.T) {
+	l := newLogger(&Config{}, newHandlers(nil))
+	h := l.AdminHandler()
+	// gen returns the generation of the last change, the revert timer is fired by
+	// calling expire instead of waiting for the ttl.
+	gen := func() uint64 {
+		l.levels.mu.Lock()
+		defer l.levels.mu.Unlock()
+		return l.levels.gen
+	}
+
+	adminDo(t, h, http.MethodPut, "/?level=debug&ttl=1h", "", "")
+	stale := gen()
+	_, resp := adminDo(t, h, http.MethodPut, "/?v=2&ttl=1h", "", "")
+	assert.Equal(t, levelsResp{Level: "DEBUG", V: 2}, resp)
+
+	// the revert of the replaced change is ignored.
+	l.levels.expire(stale)
+	assert.Equal(t, DebugLevel, l.Level())
+	l.levels.expire(gen())
+	_, resp = adminDo(t, h, http.MethodGet, "/", "", "")
+	assert.Equal(t, levelsResp{Level: "INFO", V: 0}, resp)
+
+	adminDo(t, h, http.MethodPut, "/?level=error&ttl=1h", "", "")
+	stale = gen()
+	l.SetLevel(WarnLevel)
+	l.levels.expire(stale)
+	assert.Equal(t, WarnLevel, l.Level())
+}
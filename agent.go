@@ -0,0 +1,238 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hxchjm/log/core"
+	"github.com/hxchjm/log/env"
+)
+
+const (
+	_agentTimeout = 20 * time.Millisecond
+	_agentChan    = 2048
+	_agentBuffer  = 100
+	_mergeWait    = 1 * time.Second
+	_maxBuffer    = 10 * 1024 * 1024 // 10mb
+
+	// the collector is redialed after a failure no sooner than the backoff,
+	// which doubles on every failure.
+	_agentBackoffMin = 100 * time.Millisecond
+	_agentBackoffMax = 10 * time.Second
+
+	_defaultAgentConfig = "unix:///var/run/lancer/collector.sock?timeout=100ms&chan=1024"
+)
+
+// AgentConfig agent config, see NewAgent.
+type AgentConfig struct {
+	// Proto network of the collector, unix or tcp.
+	Proto string
+	// Addr socket path or host:port of the collector.
+	Addr string
+	// Chan size of the entry queue, entries are dropped when it's full.
+	Chan int
+	// Buffer max number of entries written to the collector at once.
+	Buffer int
+	// Timeout of dialing and writing.
+	Timeout time.Duration
+}
+
+// parseDSN parses the agent dsn, e.g.
+// unix:///var/run/lancer/collector.sock?timeout=100ms&chan=1024&buffer=100
+// tcp://127.0.0.1:9000?timeout=100ms
+func parseDSN(dsn string) (*AgentConfig, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("log: invalid agent dsn %q: %v", dsn, err)
+	}
+	ac := &AgentConfig{Proto: u.Scheme}
+	switch u.Scheme {
+	case "unix":
+		ac.Addr = u.Path
+	case "tcp":
+		ac.Addr = u.Host
+	default:
+		return nil, fmt.Errorf("log: invalid agent dsn %q: unknown network %q", dsn, u.Scheme)
+	}
+	if ac.Addr == "" {
+		return nil, fmt.Errorf("log: invalid agent dsn %q: empty address", dsn)
+	}
+	q := u.Query()
+	if s := q.Get("chan"); s != "" {
+		if ac.Chan, err = strconv.Atoi(s); err != nil {
+			return nil, fmt.Errorf("log: invalid agent dsn %q: chan %q", dsn, s)
+		}
+	}
+	if s := q.Get("buffer"); s != "" {
+		if ac.Buffer, err = strconv.Atoi(s); err != nil {
+			return nil, fmt.Errorf("log: invalid agent dsn %q: buffer %q", dsn, s)
+		}
+	}
+	if s := q.Get("timeout"); s != "" {
+		if ac.Timeout, err = time.ParseDuration(s); err != nil {
+			return nil, fmt.Errorf("log: invalid agent dsn %q: timeout %q", dsn, s)
+		}
+	}
+	return ac, nil
+}
+
+// AgentHandler sends entries as JSON lines to the log collector on the host,
+// the entries are written to stderr while the collector is down.
+type AgentHandler struct {
+	enc    entryEncoder
+	fields []D
+	level  *atomicLevel
+	a      *agent
+}
+
+// agent is the connection to the collector shared by an AgentHandler and its children.
+type agent struct {
+	c      *AgentConfig
+	msgs   chan *core.Buffer
+	done   chan struct{}
+	once   sync.Once
+	waiter sync.WaitGroup
+	nc     *netConn
+}
+
+// NewAgent create an agent handler handles all levels, nil ac means using the -log.agent
+// flag or LOG_AGENT env variable, it panics if the dsn is invalid.
+func NewAgent(ac *AgentConfig) *AgentHandler {
+	handler, err := newAgent(ac)
+	if err != nil {
+		panic(err)
+	}
+	return handler
+}
+
+func newAgent(ac *AgentConfig) (*AgentHandler, error) {
+	if ac == nil {
+		var err error
+		if ac, err = parseDSN(_agentDSN); err != nil {
+			return nil, err
+		}
+	}
+	c := *ac
+	if c.Chan <= 0 {
+		c.Chan = _agentChan
+	}
+	if c.Buffer <= 0 {
+		c.Buffer = _agentBuffer
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = _agentTimeout
+	}
+	a := &agent{
+		c:    &c,
+		msgs: make(chan *core.Buffer, c.Chan),
+		done: make(chan struct{}),
+		nc: newNetConn("log-agent ", c.Timeout, func() (net.Conn, error) {
+			return net.DialTimeout(c.Proto, c.Addr, c.Timeout)
+		}),
+	}
+	a.waiter.Add(1)
+	go a.writeproc()
+	fields := []D{KVString(_deplyEnv, env.DeployEnv)}
+	return &AgentHandler{
+		enc:    newEntryEncoder(FormatJSON, fields),
+		fields: fields,
+		level:  newAtomicLevel(_debugLevel),
+		a:      a,
+	}, nil
+}
+
+// Log queues the entry for the collector, it's dropped if the queue is full.
+func (h *AgentHandler) Log(ctx context.Context, lv Level, args ...D) {
+	buf := core.GetPool()
+	if err := h.enc.Encode(buf, args); err != nil {
+		buf.Free()
+		return
+	}
+	select {
+	case h.a.msgs <- buf:
+	default:
+		buf.Free()
+	}
+}
+
+// With returns an agent handler shares the connection and attaches fields to every entry.
+func (h *AgentHandler) With(fields ...D) Handler {
+	bound := append(h.fields[:len(h.fields):len(h.fields)], fields...)
+	return &AgentHandler{enc: h.enc.With(fields), fields: bound, level: h.level, a: h.a}
+}
+
+// SetLevel set the minimum level of agent handler.
+func (h *AgentHandler) SetLevel(lv Level) {
+	h.level.SetLevel(lv)
+}
+
+// Enabled reports whether lv is at or above the minimum level.
+func (h *AgentHandler) Enabled(lv Level) bool {
+	return h.level.Enabled(lv)
+}
+
+// Close flushes the queued entries and closes the connection.
+func (h *AgentHandler) Close() error {
+	h.a.once.Do(func() {
+		close(h.a.done)
+	})
+	h.a.waiter.Wait()
+	return nil
+}
+
+// SetFormat is ignored, the collector always receives JSON lines.
+func (h *AgentHandler) SetFormat(string) {}
+
+// writeproc batches the entries and writes them into the connection.
+func (a *agent) writeproc() {
+	var count int
+	defer a.waiter.Done()
+	batch := core.NewBuffer(2048)
+	flush := func() {
+		if batch.Len() == 0 {
+			return
+		}
+		count = 0
+		a.nc.write(batch.Bytes(), writeConn)
+		batch.Reset()
+	}
+	add := func(buf *core.Buffer) {
+		batch.Write(buf.Bytes())
+		buf.Free()
+		if count++; count >= a.c.Buffer || batch.Len() >= _maxBuffer {
+			flush()
+		}
+	}
+	tick := time.NewTicker(_mergeWait)
+	defer tick.Stop()
+	for {
+		select {
+		case buf := <-a.msgs:
+			add(buf)
+		case <-tick.C:
+			flush()
+		case <-a.done:
+			for len(a.msgs) != 0 {
+				add(<-a.msgs)
+			}
+			flush()
+			a.nc.close()
+			return
+		}
+	}
+}
+
+func nextBackoff(backoff time.Duration) time.Duration {
+	if backoff *= 2; backoff < _agentBackoffMin {
+		return _agentBackoffMin
+	}
+	if backoff > _agentBackoffMax {
+		return _agentBackoffMax
+	}
+	return backoff
+}
@@ -0,0 +1,159 @@
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// notifyWriter is a fallback writer signals every write.
+type notifyWriter struct {
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	wrote chan struct{}
+}
+
+func newNotifyWriter() *notifyWriter {
+	return &notifyWriter{wrote: make(chan struct{}, 16)}
+}
+
+func (w *notifyWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf.Write(p)
+	w.wrote <- struct{}{}
+	return len(p), nil
+}
+
+func (w *notifyWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.String()
+}
+
+// wait waits for a write.
+func (w *notifyWriter) wait(t *testing.T) {
+	select {
+	case <-w.wrote:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+}
+
+func TestParseDSN(t *testing.T) {
+	ac, err := parseDSN("unix:///var/run/collector.sock?timeout=100ms&chan=1024&buffer=10")
+	if assert.NoError(t, err) {
+		assert.Equal(t, &AgentConfig{Proto: "unix", Addr: "/var/run/collector.sock", Chan: 1024, Buffer: 10, Timeout: 100 * time.Millisecond}, ac)
+	}
+	ac, err = parseDSN("tcp://127.0.0.1:9000")
+	if assert.NoError(t, err) {
+		assert.Equal(t, &AgentConfig{Proto: "tcp", Addr: "127.0.0.1:9000"}, ac)
+	}
+	for _, dsn := range []string{"udp://127.0.0.1:9000", "unix://", "tcp://127.0.0.1:9000?chan=x", "tcp://127.0.0.1:9000?timeout=1"} {
+		_, err = parseDSN(dsn)
+		assert.Error(t, err, dsn)
+	}
+}
+
+// collect accepts a connection of ln and sends the entries it receives to the returned channel.
+func collect(ln net.Listener) <-chan map[string]interface{} {
+	ch := make(chan map[string]interface{}, 16)
+	go func() {
+		defer close(ch)
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		sc := bufio.NewScanner(conn)
+		for sc.Scan() {
+			var e map[string]interface{}
+			if json.Unmarshal(sc.Bytes(), &e) == nil {
+				ch <- e
+			}
+		}
+	}()
+	return ch
+}
+
+func TestAgent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log-agent")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	addr := filepath.Join(dir, "collector.sock")
+	ln, err := net.Listen("unix", addr)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer ln.Close()
+	entries := collect(ln)
+
+	ah, err := newAgent(&AgentConfig{Proto: "unix", Addr: addr, Buffer: 2})
+	if !assert.NoError(t, err) {
+		return
+	}
+	l := newLogger(&Config{Family: "app"}, newHandlers(nil, ah))
+	l.Info("a")
+	l.With(KVInt("uid", 1)).Warn("b")
+	l.Error("c")
+	assert.NoError(t, l.Close())
+
+	var got []map[string]interface{}
+	for e := range entries {
+		got = append(got, e)
+	}
+	if assert.Len(t, got, 3) {
+		assert.Equal(t, "a", got[0][_log])
+		assert.Equal(t, "app", got[0][_appID])
+		assert.Equal(t, "WARN", got[1][_level])
+		assert.Equal(t, float64(1), got[1]["uid"])
+		assert.Equal(t, "c", got[2][_log])
+	}
+}
+
+func TestAgentFallback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log-agent")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	addr := filepath.Join(dir, "collector.sock")
+
+	ah, err := newAgent(&AgentConfig{Proto: "unix", Addr: addr, Buffer: 1})
+	if !assert.NoError(t, err) {
+		return
+	}
+	fallback := newNotifyWriter()
+	clock := newFakeClock()
+	ah.a.nc.fallback = fallback
+	ah.a.nc.now = clock.Now
+	l := newLogger(&Config{}, newHandlers(nil, ah))
+	l.Info("down")
+	fallback.wait(t)
+
+	ln, err := net.Listen("unix", addr)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer ln.Close()
+	entries := collect(ln)
+	clock.Add(_agentBackoffMin)
+	l.Info("up")
+	assert.NoError(t, l.Close())
+
+	assert.Contains(t, fallback.String(), `"log":"down"`)
+	assert.NotContains(t, fallback.String(), `"log":"up"`)
+	e := <-entries
+	assert.Equal(t, "up", e[_log])
+}
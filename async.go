@@ -0,0 +1,287 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// overflow policies of AsyncConfig.
+const (
+	// OverflowBlock blocks the caller until the queue has room.
+	OverflowBlock = "block"
+	// OverflowBlockTimeout blocks the caller at most AsyncConfig.Timeout then drops the entry.
+	OverflowBlockTimeout = "block_timeout"
+	// OverflowDropNewest drops the entry being logged.
+	OverflowDropNewest = "drop_newest"
+	// OverflowDropOldest drops the oldest queued entry to make room.
+	OverflowDropOldest = "drop_oldest"
+	// OverflowDropBelow drops the entries below AsyncConfig.DropBelow and blocks the others.
+	OverflowDropBelow = "drop_below"
+)
+
+const (
+	_asyncSize    = 1024
+	_asyncTimeout = 100 * time.Millisecond
+	_asyncSummary = time.Minute
+)
+
+// AsyncConfig async handler config, see Async.
+type AsyncConfig struct {
+	// Size of the queue, 0 means 1024.
+	Size int
+	// Overflow policy when the queue is full, empty means drop_newest.
+	Overflow string
+	// Timeout of block_timeout, 0 means 100ms.
+	Timeout time.Duration
+	// DropBelow level of drop_below, e.g. WARN, empty means INFO.
+	DropBelow string
+	// Summary interval of the "dropped N entries" entry, which is logged by the wrapped
+	// handler if any entry is dropped in the interval, 0 means 1 minute.
+	Summary time.Duration
+}
+
+// AsyncHandler hands the entries to the wrapped handler on its own goroutine through a
+// bounded queue, so a slow sink never stalls the callers. The values of fields must
+// not be changed after logging because they are encoded later.
+type AsyncHandler struct {
+	h Handler
+	q *asyncQueue
+}
+
+// asyncEntry is a queued entry and the handler it's logged to.
+type asyncEntry struct {
+	ctx  context.Context
+	h    Handler
+	lv   Level
+	args []D
+}
+
+// asyncQueue is the queue and the goroutine shared by an AsyncHandler and its children.
+type asyncQueue struct {
+	overflow string
+	timeout  time.Duration
+	below    Level
+	summary  time.Duration
+
+	ch     chan asyncEntry
+	done   chan struct{}
+	once   sync.Once
+	waiter sync.WaitGroup
+	// mu guards closed, the entries are sent with mu read locked, so no entry is sent
+	// after proc has drained the queue and returned.
+	mu     sync.RWMutex
+	closed bool
+
+	// dropped counts the dropped entries of each level.
+	dropped [_offLevel]uint64
+	// reported is the total of dropped when the last summary is logged.
+	reported uint64
+}
+
+// Async wraps h into an AsyncHandler, nil conf means the defaults, it panics if conf is invalid.
+func Async(h Handler, conf *AsyncConfig) *AsyncHandler {
+	handler, err := newAsync(h, conf)
+	if err != nil {
+		panic(err)
+	}
+	return handler
+}
+
+func newAsync(h Handler, conf *AsyncConfig) (*AsyncHandler, error) {
+	c := AsyncConfig{}
+	if conf != nil {
+		c = *conf
+	}
+	q := &asyncQueue{
+		overflow: c.Overflow,
+		timeout:  c.Timeout,
+		below:    _infoLevel,
+		summary:  c.Summary,
+		done:     make(chan struct{}),
+	}
+	switch q.overflow {
+	case "":
+		q.overflow = OverflowDropNewest
+	case OverflowBlock, OverflowBlockTimeout, OverflowDropNewest, OverflowDropOldest, OverflowDropBelow:
+	default:
+		return nil, fmt.Errorf("log: unknown async overflow policy %q", c.Overflow)
+	}
+	if c.DropBelow != "" {
+		lv, ok := parseLevel(c.DropBelow)
+		if !ok {
+			return nil, fmt.Errorf("log: invalid async drop below level %q", c.DropBelow)
+		}
+		q.below = lv
+	}
+	if c.Size <= 0 {
+		c.Size = _asyncSize
+	}
+	if q.timeout <= 0 {
+		q.timeout = _asyncTimeout
+	}
+	if q.summary <= 0 {
+		q.summary = _asyncSummary
+	}
+	q.ch = make(chan asyncEntry, c.Size)
+	q.waiter.Add(1)
+	go q.proc(h)
+	return &AsyncHandler{h: h, q: q}, nil
+}
+
+// Log queues the entry, it's handled by the overflow policy if the queue is full.
+func (h *AsyncHandler) Log(ctx context.Context, lv Level, args ...D) {
+	e := asyncEntry{ctx: ctx, h: h.h, lv: lv, args: append([]D(nil), args...)}
+	q := h.q
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		q.drop(lv)
+		return
+	}
+	select {
+	case q.ch <- e:
+		return
+	default:
+	}
+	switch q.overflow {
+	case OverflowDropNewest:
+		q.drop(lv)
+	case OverflowDropOldest:
+		for {
+			select {
+			case q.ch <- e:
+				return
+			default:
+			}
+			select {
+			case old := <-q.ch:
+				q.drop(old.lv)
+			default:
+			}
+		}
+	case OverflowBlockTimeout:
+		timer := time.NewTimer(q.timeout)
+		defer timer.Stop()
+		select {
+		case q.ch <- e:
+		case <-timer.C:
+			q.drop(lv)
+		}
+	case OverflowDropBelow:
+		if lv < q.below {
+			q.drop(lv)
+			return
+		}
+		fallthrough
+	case OverflowBlock:
+		// proc keeps receiving until Close acquires mu.
+		q.ch <- e
+	}
+}
+
+// With returns an async handler shares the queue and attaches fields to every entry.
+func (h *AsyncHandler) With(fields ...D) Handler {
+	return &AsyncHandler{h: withFields(h.h, fields), q: h.q}
+}
+
+// Enabled reports whether the wrapped handler is enabled for lv.
+func (h *AsyncHandler) Enabled(lv Level) bool {
+	return handlerEnabled(h.h, lv)
+}
+
+// Dropped returns the number of the dropped entries.
+func (h *AsyncHandler) Dropped() uint64 {
+	return h.q.total()
+}
+
+// Close handles the queued entries then closes the wrapped handler,
+// the entries logged after Close are dropped.
+func (h *AsyncHandler) Close() error {
+	closed := false
+	h.q.once.Do(func() {
+		// wait for the sending entries, the later ones are dropped.
+		h.q.mu.Lock()
+		h.q.closed = true
+		h.q.mu.Unlock()
+		close(h.q.done)
+		closed = true
+	})
+	h.q.waiter.Wait()
+	if !closed {
+		return nil
+	}
+	return h.h.Close()
+}
+
+// SetFormat set the format of the wrapped handler.
+func (h *AsyncHandler) SetFormat(format string) {
+	h.h.SetFormat(format)
+}
+
+func (q *asyncQueue) drop(lv Level) {
+	if lv >= _offLevel {
+		lv = _fatalLevel
+	}
+	atomic.AddUint64(&q.dropped[lv], 1)
+}
+
+func (q *asyncQueue) total() (n uint64) {
+	for i := range q.dropped {
+		n += atomic.LoadUint64(&q.dropped[i])
+	}
+	return
+}
+
+// proc logs the queued entries by their handlers, h receives the summaries.
+func (q *asyncQueue) proc(h Handler) {
+	defer q.waiter.Done()
+	tick := time.NewTicker(q.summary)
+	defer tick.Stop()
+	for {
+		select {
+		case e := <-q.ch:
+			e.h.Log(e.ctx, e.lv, e.args...)
+		case <-tick.C:
+			q.report(h)
+		case <-q.done:
+			for len(q.ch) != 0 {
+				e := <-q.ch
+				e.h.Log(e.ctx, e.lv, e.args...)
+			}
+			q.report(h)
+			return
+		}
+	}
+}
+
+// report logs a summary of the entries dropped since the last one.
+func (q *asyncQueue) report(h Handler) {
+	total := q.total()
+	n := total - q.reported
+	if n == 0 {
+		return
+	}
+	q.reported = total
+	if handlerEnabled(h, _warnLevel) {
+		h.Log(context.Background(), _warnLevel, summaryEntry("log: dropped "+strconv.FormatUint(n, 10)+" entries",
+			KVUint64("dropped", n), KVUint64("dropped_total", total))...)
+	}
+}
+
+// summaryEntry returns a WARN entry of msg and fields logged by a wrapper handler to the
+// wrapped one directly, which has the fields added by Handlers.Log.
+func summaryEntry(msg string, fields ...D) []D {
+	d := make([]D, 0, len(fields)+5)
+	d = append(d, KVString(_log, msg))
+	d = append(d, fields...)
+	return append(d,
+		KVString(_source, funcName(2)),
+		KV(_time, time.Now()),
+		KVInt64(_levelValue, int64(_warnLevel)),
+		KVString(_level, _warnLevel.String()),
+	)
+}
@@ -0,0 +1,147 @@
+package log
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// gateHandler records the entries and blocks every Log until the gate is opened.
+type gateHandler struct {
+	testHandler
+	started chan struct{}
+	gate    chan struct{}
+}
+
+func newGateHandler() *gateHandler {
+	return &gateHandler{started: make(chan struct{}, 1), gate: make(chan struct{})}
+}
+
+func (gh *gateHandler) Log(ctx context.Context, lv Level, args ...D) {
+	gh.testHandler.Log(ctx, lv, args...)
+	select {
+	case gh.started <- struct{}{}:
+	default:
+	}
+	<-gh.gate
+}
+
+func (gh *gateHandler) logs() (logs []string) {
+	gh.mu.Lock()
+	defer gh.mu.Unlock()
+	for _, e := range gh.entries {
+		logs = append(logs, e.fields[_log].(string))
+	}
+	return
+}
+
+// fillAsync logs a which blocks the gate handler, then b and c which fill the queue of size 2.
+func fillAsync(t *testing.T, conf *AsyncConfig) (*gateHandler, *AsyncHandler) {
+	gh := newGateHandler()
+	conf.Size = 2
+	ah, err := newAsync(gh, conf)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	ah.Log(context.Background(), _infoLevel, KVString(_log, "a"))
+	<-gh.started
+	ah.Log(context.Background(), _infoLevel, KVString(_log, "b"))
+	ah.Log(context.Background(), _infoLevel, KVString(_log, "c"))
+	return gh, ah
+}
+
+func TestAsyncOverflow(t *testing.T) {
+	gh, ah := fillAsync(t, &AsyncConfig{Overflow: OverflowDropNewest})
+	ah.Log(context.Background(), _errorLevel, KVString(_log, "d"))
+	assert.Equal(t, uint64(1), ah.Dropped())
+	close(gh.gate)
+	ah.Close()
+	assert.Equal(t, []string{"a", "b", "c", "log: dropped 1 entries"}, gh.logs())
+	assert.True(t, gh.closed)
+
+	gh, ah = fillAsync(t, &AsyncConfig{Overflow: OverflowDropOldest})
+	ah.Log(context.Background(), _infoLevel, KVString(_log, "d"))
+	assert.Equal(t, uint64(1), ah.Dropped())
+	close(gh.gate)
+	ah.Close()
+	assert.Equal(t, []string{"a", "c", "d", "log: dropped 1 entries"}, gh.logs())
+
+	gh, ah = fillAsync(t, &AsyncConfig{Overflow: OverflowBlockTimeout, Timeout: 20 * time.Millisecond})
+	start := time.Now()
+	ah.Log(context.Background(), _infoLevel, KVString(_log, "d"))
+	assert.True(t, time.Since(start) >= 20*time.Millisecond)
+	assert.Equal(t, uint64(1), ah.Dropped())
+	close(gh.gate)
+	ah.Close()
+
+	gh, ah = fillAsync(t, &AsyncConfig{Overflow: OverflowDropBelow, DropBelow: "WARN"})
+	ah.Log(context.Background(), _infoLevel, KVString(_log, "d"))
+	assert.Equal(t, uint64(1), ah.Dropped())
+	time.AfterFunc(20*time.Millisecond, func() { close(gh.gate) })
+	ah.Log(context.Background(), _warnLevel, KVString(_log, "e"))
+	ah.Close()
+	assert.Equal(t, []string{"a", "b", "c", "e", "log: dropped 1 entries"}, gh.logs())
+	ah.Log(context.Background(), _warnLevel, KVString(_log, "f"))
+	assert.Equal(t, uint64(2), ah.Dropped())
+
+	_, err := newAsync(gh, &AsyncConfig{Overflow: "spill"})
+	assert.Error(t, err)
+	_, err = newAsync(gh, &AsyncConfig{Overflow: OverflowDropBelow, DropBelow: "LOUD"})
+	assert.Error(t, err)
+}
+
+func TestAsyncCloseWhileLogging(t *testing.T) {
+	for _, overflow := range []string{OverflowDropNewest, OverflowBlock} {
+		th := &testHandler{}
+		ah := Async(th, &AsyncConfig{Overflow: overflow, Size: 4})
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 500; j++ {
+					ah.Log(context.Background(), _infoLevel, KVString(_log, "race"))
+				}
+			}()
+		}
+		ah.Close()
+		wg.Wait()
+		// every entry is either handled or counted as dropped.
+		th.mu.Lock()
+		handled := 0
+		for _, e := range th.entries {
+			if e.fields[_log] == "race" {
+				handled++
+			}
+		}
+		th.mu.Unlock()
+		assert.Equal(t, uint64(2000), uint64(handled)+ah.Dropped(), overflow)
+	}
+}
+
+func TestAsyncSummary(t *testing.T) {
+	gh, ah := fillAsync(t, &AsyncConfig{Summary: 20 * time.Millisecond})
+	ah.Log(context.Background(), _infoLevel, KVString(_log, "d"))
+	ah.Log(context.Background(), _infoLevel, KVString(_log, "e"))
+	close(gh.gate)
+	// the summary is logged by the ticker of the queue.
+	assert.Eventually(t, func() bool { return len(gh.logs()) == 4 }, 5*time.Second, time.Millisecond)
+	assert.Equal(t, []string{"a", "b", "c", "log: dropped 2 entries"}, gh.logs())
+	gh.mu.Lock()
+	e := gh.entries[3]
+	gh.mu.Unlock()
+	assert.Equal(t, _warnLevel, e.lv)
+	assert.Equal(t, int64(2), e.fields["dropped"])
+	ah.Close()
+
+	th := &testHandler{}
+	l := newLogger(&Config{}, newHandlers(nil, Async(th, nil)))
+	l.With(KVInt("uid", 1)).Info("async")
+	l.Close()
+	if assert.Len(t, th.entries, 1) {
+		assert.Equal(t, int64(1), th.entries[0].fields["uid"])
+	}
+}
@@ -0,0 +1,222 @@
+package log
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hxchjm/log/core"
+)
+
+// ANSI escape codes of the console handler.
+const (
+	_colorReset  = "\x1b[0m"
+	_colorDim    = "\x1b[2m"
+	_colorRed    = "\x1b[31m"
+	_colorYellow = "\x1b[33m"
+	_colorFatal  = "\x1b[1;31m"
+)
+
+// _consoleIndent indents the continuation lines of multi-line values.
+const _consoleIndent = "    "
+
+// ConsoleHandler writes human-friendly entries for local development, e.g.
+//
+//	15:04:05.000 ERROR log/dao.go:12 query failed uid=1 app_id=main.app
+//
+// the level is colored and aligned, the fields are sorted key=value, the internal
+// fields are dimmed and the multi-line values are indented on the following lines.
+// The caller is relative to the module root. SetFormat replaces the console output by
+// a pattern or JSON, see WriterHandler.SetFormat.
+type ConsoleHandler struct {
+	*WriterHandler
+}
+
+// NewConsole create a console handler writes to w handles all levels, it's colored if w
+// is a terminal and the NO_COLOR env variable is not set, see https://no-color.org.
+func NewConsole(w io.Writer) *ConsoleHandler {
+	return &ConsoleHandler{newWriterHandler(w, &consoleEncoder{color: isTerminal(w) && os.Getenv("NO_COLOR") == ""})}
+}
+
+// With returns a console handler attaches fields to every entry.
+func (h *ConsoleHandler) With(fields ...D) Handler {
+	return &ConsoleHandler{h.with(fields)}
+}
+
+// isTerminal reports whether w is a character device, e.g. a tty.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// consoleEncoder encodes entries for ConsoleHandler.
+type consoleEncoder struct {
+	color  bool
+	fields []D
+}
+
+// Encode implement entryEncoder.
+func (ce *consoleEncoder) Encode(buf *core.Buffer, args []D) error {
+	var (
+		lv, msg, source string
+		ts              = time.Now()
+		fields          []D
+		internal        []D
+	)
+	add := func(f D) {
+		switch f.Key {
+		case _level:
+			lv = f.StringVal
+		case _log:
+			msg = f.StringVal
+		case _source:
+			source = f.StringVal
+		case _time:
+			if t, ok := f.Value.(time.Time); ok {
+				ts = t
+			}
+		case _levelValue, _funcName:
+		default:
+			if isInternalKey(f.Key) {
+				if f.StringVal != "" {
+					internal = append(internal, f)
+				}
+				return
+			}
+			fields = append(fields, f)
+		}
+	}
+	for _, f := range args {
+		add(f)
+	}
+	for _, f := range ce.fields {
+		if !hasKey(args, f.Key) {
+			add(f)
+		}
+	}
+	sort.SliceStable(fields, func(i, j int) bool { return fields[i].Key < fields[j].Key })
+	sort.SliceStable(internal, func(i, j int) bool { return internal[i].Key < internal[j].Key })
+
+	ce.colored(buf, _colorDim, ts.Format("15:04:05.000"))
+	buf.AppendByte(' ')
+	ce.colored(buf, levelColor(lv), lv)
+	for i := len(lv); i < 5; i++ {
+		buf.AppendByte(' ')
+	}
+	if source != "" {
+		buf.AppendByte(' ')
+		ce.colored(buf, _colorDim, relSource(source))
+	}
+	buf.AppendByte(' ')
+	buf.AppendString(strings.Replace(msg, "\n", "\n"+_consoleIndent, -1))
+	var multi []D
+	for _, f := range fields {
+		v := fieldString(f)
+		if strings.Contains(v, "\n") {
+			multi = append(multi, f)
+			continue
+		}
+		buf.AppendByte(' ')
+		ce.colored(buf, _colorDim, f.Key+"=")
+		buf.AppendString(consoleQuote(v))
+	}
+	for _, f := range internal {
+		buf.AppendByte(' ')
+		ce.colored(buf, _colorDim, f.Key+"="+consoleQuote(f.StringVal))
+	}
+	for _, f := range multi {
+		buf.AppendString("\n" + _consoleIndent)
+		ce.colored(buf, _colorDim, f.Key+"=")
+		v := strings.TrimRight(fieldString(f), "\n")
+		buf.AppendString("\n" + _consoleIndent + _consoleIndent)
+		buf.AppendString(strings.Replace(v, "\n", "\n"+_consoleIndent+_consoleIndent, -1))
+	}
+	buf.AppendByte('\n')
+	return nil
+}
+
+// With implement entryEncoder, the fields of an entry override the bound ones.
+func (ce *consoleEncoder) With(fields []D) entryEncoder {
+	bound := make([]D, 0, len(ce.fields)+len(fields))
+	for _, f := range ce.fields {
+		if !hasKey(fields, f.Key) {
+			bound = append(bound, f)
+		}
+	}
+	return &consoleEncoder{color: ce.color, fields: append(bound, fields...)}
+}
+
+// colored appends s wrapped by the color code if the encoder is colored.
+func (ce *consoleEncoder) colored(buf *core.Buffer, color, s string) {
+	if !ce.color || color == "" {
+		buf.AppendString(s)
+		return
+	}
+	buf.AppendString(color)
+	buf.AppendString(s)
+	buf.AppendString(_colorReset)
+}
+
+func levelColor(lv string) string {
+	switch lv {
+	case "WARN":
+		return _colorYellow
+	case "ERROR":
+		return _colorRed
+	case "FATAL":
+		return _colorFatal
+	}
+	return ""
+}
+
+// consoleQuote quotes v if it's empty or contains spaces, quotes or =.
+func consoleQuote(v string) string {
+	if v == "" || strings.ContainsAny(v, " \t\"=") {
+		return strconv.Quote(v)
+	}
+	return v
+}
+
+// _moduleRoots caches the module root of a source directory, "" means not found.
+var _moduleRoots sync.Map
+
+// relSource returns source relative to the root of its module, i.e. the nearest
+// directory contains go.mod, or the last two elements of source if it's not found.
+func relSource(source string) string {
+	file := source
+	if i := strings.LastIndexByte(source, ':'); i > 0 {
+		file = source[:i]
+	}
+	dir := filepath.Dir(file)
+	root, ok := _moduleRoots.Load(dir)
+	if !ok {
+		root = ""
+		for d := dir; ; {
+			if _, err := os.Stat(filepath.Join(d, "go.mod")); err == nil {
+				root = d
+				break
+			}
+			parent := filepath.Dir(d)
+			if parent == d {
+				break
+			}
+			d = parent
+		}
+		_moduleRoots.Store(dir, root)
+	}
+	if r := root.(string); r != "" {
+		if rel, err := filepath.Rel(r, file); err == nil {
+			return filepath.ToSlash(rel) + source[len(file):]
+		}
+	}
+	return filepath.Base(dir) + "/" + filepath.Base(file) + source[len(file):]
+}
@@ -0,0 +1,115 @@
+package log
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// Extractor extracts the fields of an entry from its context, e.g. the trace id or the
+// caller set by a middleware. It's called for every entry so it should be cheap.
+type Extractor func(ctx context.Context) []D
+
+// ctxKey is the type of the context keys of this package, it never collides with others.
+type ctxKey int
+
+const (
+	_fieldsKey ctxKey = iota
+	_traceIDKey
+)
+
+// _legacyTraceKey is the string key of trace id used before NewTraceContext.
+const _legacyTraceKey = "trace_id"
+
+var (
+	_extractorMu sync.Mutex
+	_extractors  atomic.Value // []Extractor, copy on write
+)
+
+func init() {
+	_extractors.Store([]Extractor{traceExtractor, fieldsExtractor})
+}
+
+// RegisterExtractor registers an extractor for all loggers, the fields it returns are
+// added to every entry logged with a context, such as Info(ctx, ...) and Infov(ctx, ...).
+// It's safe to call while logging, but typically called in init.
+func RegisterExtractor(fn Extractor) {
+	_extractorMu.Lock()
+	defer _extractorMu.Unlock()
+	old := _extractors.Load().([]Extractor)
+	extractors := make([]Extractor, len(old), len(old)+1)
+	copy(extractors, old)
+	_extractors.Store(append(extractors, fn))
+}
+
+// Extract returns the fields of ctx from all the registered extractors,
+// which are added to every entry logged with ctx.
+func Extract(ctx context.Context) []D {
+	return extract(ctx, nil)
+}
+
+// _extraFields is the room reserved by extract for the fields Handlers.Log appends.
+const _extraFields = 4
+
+// extract returns a copy of d with the fields of ctx from all the registered extractors
+// appended, d is never modified because it may be shared by the caller. The fields of
+// ctx whose keys are in d are skipped, so the fields passed explicitly win.
+func extract(ctx context.Context, d []D) []D {
+	var extracted [][]D
+	n := len(d) + _extraFields
+	if ctx != nil {
+		for _, fn := range _extractors.Load().([]Extractor) {
+			if fields := fn(ctx); len(fields) != 0 {
+				extracted = append(extracted, fields)
+				n += len(fields)
+			}
+		}
+	}
+	out := make([]D, 0, n)
+	out = append(out, d...)
+	for _, fields := range extracted {
+		for _, f := range fields {
+			if !hasKey(d, f.Key) {
+				out = append(out, f)
+			}
+		}
+	}
+	return out
+}
+
+// NewContext returns a copy of ctx carries fields, which are added to every entry
+// logged with the context. The fields of the parent context are kept.
+func NewContext(ctx context.Context, fields ...D) context.Context {
+	if parent, ok := ctx.Value(_fieldsKey).([]D); ok {
+		fields = append(parent[:len(parent):len(parent)], fields...)
+	}
+	return context.WithValue(ctx, _fieldsKey, fields)
+}
+
+// NewTraceContext returns a copy of ctx carries the trace id, which is added to every
+// entry logged with the context as traceid.
+func NewTraceContext(ctx context.Context, traceID string) context.Context {
+	return context.WithValue(ctx, _traceIDKey, traceID)
+}
+
+// FromContext returns the trace id carried by ctx, the legacy "trace_id" string key
+// is still supported.
+func FromContext(ctx context.Context) (t string, ok bool) {
+	if t, ok = ctx.Value(_traceIDKey).(string); ok {
+		return
+	}
+	t, ok = ctx.Value(_legacyTraceKey).(string)
+	return
+}
+
+func traceExtractor(ctx context.Context) []D {
+	if t, ok := FromContext(ctx); ok {
+		return []D{KVString(_tid, t)}
+	}
+	return nil
+}
+
+func fieldsExtractor(ctx context.Context) []D {
+	fields, _ := ctx.Value(_fieldsKey).([]D)
+	return fields
+}
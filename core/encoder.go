@@ -164,6 +164,16 @@ type Encoder interface {
 	Encode(*Buffer, ...Field) error
 }
 
+// EntryEncoder is implemented by the encoders which can encode some fields ahead of
+// the accumulated context, e.g. the JSON encoder.
+type EntryEncoder interface {
+	Encoder
+
+	// EncodeEntry encodes the head fields, the accumulated context and the fields
+	// in order into a byte buffer, so that the well-known keys lead the entry.
+	EncodeEntry(buf *Buffer, head []Field, fields []Field) error
+}
+
 // A TimeEncoder serializes a time.Time to a primitive type.
 type TimeEncoder func(time.Time, PrimitiveArrayEncoder)
 
@@ -57,6 +57,8 @@ func (f Field) AddTo(enc ObjectEncoder) {
 		enc.AddFloat64(f.Key, math.Float64frombits(uint64(f.Int64Val)))
 	case DurationType:
 		enc.AddDuration(f.Key, time.Duration(f.Int64Val))
+	case BoolType:
+		enc.AddBool(f.Key, f.StringVal == "true")
 	default:
 		panic(fmt.Sprintf("unknown field type: %v", f))
 	}
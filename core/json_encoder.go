@@ -13,6 +13,7 @@ import (
 const _hex = "0123456789abcdef"
 
 var _ ObjectEncoder = &jsonEncoder{}
+var _ EntryEncoder = &jsonEncoder{}
 var _jsonPool = sync.Pool{New: func() interface{} {
 	return &jsonEncoder{}
 }}
@@ -270,6 +271,7 @@ func (enc *jsonEncoder) AppendUintptr(v uintptr)            { enc.AppendUint64(u
 
 func (enc *jsonEncoder) Clone() Encoder {
 	clone := enc.clone()
+	clone.buf.Write(enc.buf.Bytes())
 	return clone
 }
 
@@ -301,6 +303,31 @@ func (enc *jsonEncoder) Encode(buf *Buffer, fields ...Field) error {
 	return nil
 }
 
+func (enc *jsonEncoder) EncodeEntry(buf *Buffer, head []Field, fields []Field) error {
+	final := getJSONEncoder()
+	final.EncoderConfig = enc.EncoderConfig
+	final.spaced = enc.spaced
+	final.buf = buf
+	final.buf.AppendByte('{')
+	for i := range head {
+		head[i].AddTo(final)
+	}
+	if enc.buf.Len() > 0 {
+		final.addElementSeparator()
+		final.buf.Write(enc.buf.Bytes())
+	}
+	// the namespaces opened by the context are closed after the fields.
+	final.openNamespaces = enc.openNamespaces
+	for i := range fields {
+		fields[i].AddTo(final)
+	}
+
+	final.closeOpenNamespaces()
+	final.buf.AppendString("}\n")
+	putJSONEncoder(final)
+	return nil
+}
+
 func (enc *jsonEncoder) closeOpenNamespaces() {
 	for i := 0; i < enc.openNamespaces; i++ {
 		enc.buf.AppendByte('}')
@@ -0,0 +1,154 @@
+package log
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const _dedupeWindow = 10 * time.Second
+
+// DedupeConfig dedupe handler config, see Dedupe.
+type DedupeConfig struct {
+	// Window the repeated entries within Window since the first one are collapsed,
+	// 0 means 10s.
+	Window time.Duration
+}
+
+// DedupeHandler collapses the consecutive entries of the same level, source and message,
+// the first one is handed to the wrapped handler, the repeated ones are suppressed and
+// when the streak ends or the window expires the last one is handed with the fields
+// repeated=N, first_time and last_time. It keeps the output of retry loops readable.
+type DedupeHandler struct {
+	h Handler
+	d *deduper
+}
+
+// dedupeKey identifies the entries collapsed.
+type dedupeKey struct {
+	lv     Level
+	source string
+	msg    string
+}
+
+// deduper is the streak shared by a DedupeHandler and its children.
+type deduper struct {
+	window time.Duration
+
+	mu    sync.Mutex
+	key   dedupeKey
+	first time.Time
+	last  time.Time
+	count int
+	timer *time.Timer
+	// gen increases every streak, so a stale timer is ignored.
+	gen    uint64
+	closed bool
+	// the last repeated entry and the handler it's logged to.
+	ctx  context.Context
+	h    Handler
+	args []D
+}
+
+// Dedupe wraps h into a DedupeHandler, nil conf means the defaults.
+func Dedupe(h Handler, conf *DedupeConfig) *DedupeHandler {
+	d := &deduper{window: _dedupeWindow}
+	if conf != nil && conf.Window > 0 {
+		d.window = conf.Window
+	}
+	return &DedupeHandler{h: h, d: d}
+}
+
+// Log hands the entry to the wrapped handler unless it repeats the last one.
+func (h *DedupeHandler) Log(ctx context.Context, lv Level, args ...D) {
+	key := dedupeKey{lv: lv}
+	ts := time.Now()
+	for _, f := range args {
+		switch f.Key {
+		case _log:
+			key.msg = f.StringVal
+		case _source:
+			key.source = f.StringVal
+		case _time:
+			if t, ok := f.Value.(time.Time); ok {
+				ts = t
+			}
+		}
+	}
+	d := h.d
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		h.h.Log(ctx, lv, args...)
+		return
+	}
+	if key == d.key && ts.Sub(d.first) < d.window {
+		if d.count == 0 {
+			gen := d.gen
+			d.timer = time.AfterFunc(d.first.Add(d.window).Sub(time.Now()), func() { d.expire(gen) })
+		}
+		d.count++
+		d.last = ts
+		// args may be reused by the caller after Log returns.
+		d.ctx, d.h, d.args = ctx, h.h, append(d.args[:0], args...)
+		return
+	}
+	d.flush()
+	d.key, d.first = key, ts
+	d.gen++
+	h.h.Log(ctx, lv, args...)
+}
+
+// With returns a dedupe handler shares the streak and attaches fields to every entry.
+func (h *DedupeHandler) With(fields ...D) Handler {
+	return &DedupeHandler{h: withFields(h.h, fields), d: h.d}
+}
+
+// Enabled reports whether the wrapped handler is enabled for lv.
+func (h *DedupeHandler) Enabled(lv Level) bool {
+	return handlerEnabled(h.h, lv)
+}
+
+// Close hands the repeated entries then closes the wrapped handler.
+func (h *DedupeHandler) Close() error {
+	h.d.mu.Lock()
+	h.d.flush()
+	h.d.closed = true
+	h.d.mu.Unlock()
+	return h.h.Close()
+}
+
+// SetFormat set the format of the wrapped handler.
+func (h *DedupeHandler) SetFormat(format string) {
+	h.h.SetFormat(format)
+}
+
+// expire ends the streak of gen when the window expires.
+func (d *deduper) expire(gen uint64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if gen != d.gen {
+		return
+	}
+	d.flush()
+	d.key = dedupeKey{}
+}
+
+// flush hands the last repeated entry with the count of the streak, it must be called
+// with mu held.
+func (d *deduper) flush() {
+	if d.count == 0 {
+		return
+	}
+	d.timer.Stop()
+	args := make([]D, 0, len(d.args)+3)
+	args = append(args, d.args...)
+	args = append(args,
+		KVInt("repeated", d.count),
+		KVString("first_time", d.first.Format(_timeFormat)),
+		KVString("last_time", d.last.Format(_timeFormat)),
+	)
+	d.h.Log(d.ctx, d.key.lv, args...)
+	d.count = 0
+	d.ctx, d.h, d.args = nil, nil, d.args[:0]
+}
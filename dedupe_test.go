@@ -0,0 +1,82 @@
+package log
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedupe(t *testing.T) {
+	th := &testHandler{}
+	l := newLogger(&Config{}, newHandlers(nil, Dedupe(th, &DedupeConfig{Window: time.Hour})))
+	for i := 0; i < 4; i++ {
+		l.Error("retry")
+	}
+	for i := 0; i < 2; i++ {
+		l.Info("done")
+	}
+	assert.NoError(t, l.Close())
+	if assert.Len(t, th.entries, 4) {
+		assert.Equal(t, "retry", th.entries[0].fields[_log])
+		assert.NotContains(t, th.entries[0].fields, "repeated")
+		assert.Equal(t, "retry", th.entries[1].fields[_log])
+		assert.Equal(t, _errorLevel, th.entries[1].lv)
+		assert.Equal(t, int64(3), th.entries[1].fields["repeated"])
+		assert.NotEmpty(t, th.entries[1].fields["first_time"])
+		assert.NotEmpty(t, th.entries[1].fields["last_time"])
+		assert.Equal(t, "done", th.entries[2].fields[_log])
+		assert.Equal(t, int64(1), th.entries[3].fields["repeated"])
+	}
+}
+
+func TestDedupeWindow(t *testing.T) {
+	th := &testHandler{}
+	dh := Dedupe(th, &DedupeConfig{Window: time.Minute})
+	t0 := time.Now()
+	retry := func(ts time.Time) {
+		dh.Log(context.Background(), _warnLevel, KVString(_log, "retry"), KV(_time, ts))
+	}
+	retry(t0)
+	retry(t0.Add(time.Second))
+	// the entry beyond the window since the first one ends the streak.
+	retry(t0.Add(time.Minute))
+	if assert.Len(t, th.entries, 3) {
+		assert.Equal(t, int64(1), th.entries[1].fields["repeated"])
+		assert.NotContains(t, th.entries[2].fields, "repeated")
+	}
+
+	// the timer of the window ends the streak without any entry.
+	retry(t0.Add(time.Minute + time.Second))
+	dh.d.mu.Lock()
+	gen := dh.d.gen
+	dh.d.mu.Unlock()
+	dh.d.expire(gen)
+	if assert.Len(t, th.entries, 4) {
+		assert.Equal(t, int64(1), th.entries[3].fields["repeated"])
+	}
+	// a stale timer is ignored.
+	dh.d.expire(gen - 1)
+	retry(t0.Add(time.Minute + 2*time.Second))
+	assert.NoError(t, dh.Close())
+	if assert.Len(t, th.entries, 5) {
+		assert.NotContains(t, th.entries[4].fields, "repeated")
+	}
+}
+
+func TestDedupeCopiesArgs(t *testing.T) {
+	th := &testHandler{}
+	dh := Dedupe(th, nil)
+	args := []D{KVString(_log, "retry"), KVInt("attempt", 1)}
+	for i := 1; i <= 3; i++ {
+		args[1] = KVInt("attempt", i)
+		dh.Log(context.Background(), _warnLevel, args...)
+	}
+	args[1] = KVInt("attempt", 100)
+	assert.NoError(t, dh.Close())
+	if assert.Len(t, th.entries, 2) {
+		assert.Equal(t, int64(3), th.entries[1].fields["attempt"])
+		assert.Equal(t, int64(2), th.entries[1].fields["repeated"])
+	}
+}
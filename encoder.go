@@ -0,0 +1,189 @@
+package log
+
+import (
+	"time"
+
+	"github.com/hxchjm/log/core"
+)
+
+// FormatJSON is the format of JSON lines output, one object per entry, see SetFormat.
+const FormatJSON = "json"
+
+// entryEncoder encodes an entry into bytes for the handlers write to files or streams.
+type entryEncoder interface {
+	// Encode encodes the entry into buf with a trailing newline.
+	Encode(buf *core.Buffer, args []D) error
+	// With returns an encoder attaches fields to every entry, the fields are encoded once.
+	With(fields []D) entryEncoder
+}
+
+// newEntryEncoder returns a JSON encoder if format is FormatJSON, otherwise a pattern one.
+func newEntryEncoder(format string, fields []D) entryEncoder {
+	var enc entryEncoder
+	if format == FormatJSON {
+		enc = newJSONEntryEncoder()
+	} else {
+		enc = &patternEncoder{render: newPatternRender(format + "\n")}
+	}
+	if len(fields) != 0 {
+		enc = enc.With(fields)
+	}
+	return enc
+}
+
+// patternEncoder encodes entries by the pattern render.
+type patternEncoder struct {
+	render Render
+	fields map[string]interface{}
+}
+
+// Encode implement entryEncoder.
+func (pe *patternEncoder) Encode(buf *core.Buffer, args []D) error {
+	d := toMap(args...)
+	bindFields(d, pe.fields)
+	d[_time] = time.Now().Format(_timeFormat)
+	return pe.render.Render(buf, d)
+}
+
+// With implement entryEncoder, the fields are converted once.
+func (pe *patternEncoder) With(fields []D) entryEncoder {
+	return &patternEncoder{render: pe.render, fields: mergeFields(pe.fields, fields)}
+}
+
+var _jsonConfig = core.EncoderConfig{
+	EncodeTime:     core.EpochTimeEncoder,
+	EncodeDuration: core.SecondsDurationEncoder,
+}
+
+// _jsonHeadKeys lead every JSON entry in order, followed by the bound and entry fields.
+var _jsonHeadKeys = [...]string{_time, _level, _appID, _instanceID, _tid, _source, _log}
+
+// jsonEntryEncoder encodes entries as JSON lines by core.NewJSONEncoder.
+type jsonEntryEncoder struct {
+	boundEncoder
+}
+
+func newJSONEntryEncoder() *jsonEntryEncoder {
+	return &jsonEntryEncoder{newBoundEncoder()}
+}
+
+// Encode implement entryEncoder, the first one of the fields of the same key is kept.
+func (je *jsonEntryEncoder) Encode(buf *core.Buffer, args []D) error {
+	var (
+		head  [len(_jsonHeadKeys)]D
+		found [len(_jsonHeadKeys)]bool
+	)
+	fields := make([]D, 0, len(args))
+	for _, arg := range args {
+		if i := jsonHeadIndex(arg.Key); i >= 0 {
+			if !found[i] {
+				head[i], found[i] = arg, true
+			}
+			continue
+		}
+		if jsonSkipKey(arg.Key) || hasKey(fields, arg.Key) {
+			continue
+		}
+		fields = append(fields, arg)
+	}
+	n := 0
+	for i := range head {
+		if found[i] {
+			head[n] = head[i]
+			n++
+		}
+	}
+	return je.encode(buf, head[:n], fields)
+}
+
+// With implement entryEncoder, the fields are encoded once into a clone of the encoder.
+func (je *jsonEntryEncoder) With(fields []D) entryEncoder {
+	bound := make([]D, 0, len(fields))
+	for _, f := range fields {
+		if jsonHeadIndex(f.Key) >= 0 || jsonSkipKey(f.Key) {
+			continue
+		}
+		bound = append(bound, f)
+	}
+	return &jsonEntryEncoder{je.with(bound)}
+}
+
+// boundEncoder is a JSON encoder with the fields bound by With encoded once, a bound
+// field is dropped if the entry or a later With has a field of the same key, so the
+// keys of an object are unique.
+type boundEncoder struct {
+	// base has no bound fields.
+	base core.Encoder
+	// enc holds the bound fields.
+	enc   core.Encoder
+	bound []D
+}
+
+func newBoundEncoder() boundEncoder {
+	enc := core.NewJSONEncoder(_jsonConfig, core.GetPool())
+	return boundEncoder{base: enc, enc: enc}
+}
+
+// with returns an encoder binds fields too.
+func (be *boundEncoder) with(fields []D) boundEncoder {
+	bound := make([]D, 0, len(be.bound)+len(fields))
+	for _, f := range be.bound {
+		if !hasKey(fields, f.Key) {
+			bound = append(bound, f)
+		}
+	}
+	for i, f := range fields {
+		if !hasKey(fields[i+1:], f.Key) {
+			bound = append(bound, f)
+		}
+	}
+	enc := be.base.Clone()
+	for _, f := range bound {
+		f.AddTo(enc)
+	}
+	return boundEncoder{base: be.base, enc: enc, bound: bound}
+}
+
+// encode encodes the head fields, the bound fields and the fields in order, the bound
+// fields overridden by fields are dropped.
+func (be *boundEncoder) encode(buf *core.Buffer, head, fields []D) error {
+	for _, f := range fields {
+		if !hasKey(be.bound, f.Key) {
+			continue
+		}
+		// rare, the bound fields are encoded again without the overridden ones.
+		all := make([]D, 0, len(be.bound)+len(fields))
+		for _, b := range be.bound {
+			if !hasKey(fields, b.Key) {
+				all = append(all, b)
+			}
+		}
+		return encodeEntry(be.base, buf, head, append(all, fields...))
+	}
+	return encodeEntry(be.enc, buf, head, fields)
+}
+
+func jsonHeadIndex(key string) int {
+	for i, k := range _jsonHeadKeys {
+		if k == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// jsonSkipKey reports whether key is only used by the pattern render.
+func jsonSkipKey(key string) bool {
+	return key == _levelValue || key == _funcName
+}
+
+// encodeEntry encodes the head fields ahead of the accumulated context if enc is a
+// core.EntryEncoder, otherwise they follow the context.
+func encodeEntry(enc core.Encoder, buf *core.Buffer, head, fields []D) error {
+	if ee, ok := enc.(core.EntryEncoder); ok {
+		return ee.EncodeEntry(buf, head, fields)
+	}
+	all := make([]D, 0, len(head)+len(fields))
+	all = append(all, head...)
+	return enc.Encode(buf, append(all, fields...)...)
+}
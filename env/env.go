@@ -68,6 +68,8 @@ func init() {
 	if Hostname, err = os.Hostname(); err != nil || Hostname == "" {
 		Hostname = os.Getenv("HOSTNAME")
 	}
+	// the deploy.env flag is left to the applications, the variable is read without it.
+	DeployEnv = defaultString("DEPLOY_ENV", _deployEnv)
 
 	addFlag(flag.CommandLine)
 }
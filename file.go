@@ -2,87 +2,305 @@ package log
 
 import (
 	"context"
-	"io"
+	"fmt"
 	"path/filepath"
-	"time"
+	"sync/atomic"
 
+	"github.com/hxchjm/log/core"
 	"github.com/hxchjm/log/filewriter"
 )
 
-// level idx
-const (
-	_infoIdx = iota
-	_warnIdx
-	_errorIdx
-	_totalIdx
-)
+const _filePattern = "[%D %T] [%L] [%S] %M"
+
+// _fileRoutes are the default routes, DEBUG and INFO go to info.log, WARN to warning.log,
+// ERROR and FATAL to error.log.
+var _fileRoutes = []FileRoute{
+	{Name: "info.log", MaxLevel: "INFO"},
+	{Name: "warning.log", MinLevel: "WARN", MaxLevel: "WARN"},
+	{Name: "error.log", MinLevel: "ERROR"},
+}
+
+// _cascadeRoutes are the cascading routes, every file receives its level and above,
+// info.log receives all entries in order.
+var _cascadeRoutes = []FileRoute{
+	{Name: "info.log"},
+	{Name: "warning.log", MinLevel: "WARN"},
+	{Name: "error.log", MinLevel: "ERROR"},
+}
+
+// FileRoute routes the entries matched to a log file, an entry matches if its level is in
+// [MinLevel, MaxLevel] and it has the field Field if set. For example:
+//
+//	{Name: "audit.log", Field: "category", Value: "audit", Stop: true}
+//	{Name: "slow.log", Field: "slow_query"}
+//	{Name: "app.log", Fallback: true}
+type FileRoute struct {
+	// Name file name in the log dir, the routes of the same name share the file.
+	Name string
+	// MinLevel e.g. INFO, empty means DEBUG.
+	MinLevel string
+	// MaxLevel e.g. WARN, empty means FATAL.
+	MaxLevel string
+	// Field key of the field the entry must have, empty means any entry.
+	Field string
+	// Value of Field the entry must have, empty means any value.
+	Value string
+	// Stop the entries matched are not routed to the routes after it.
+	Stop bool
+	// Fallback the route receives the entries no other route matched, the conditions above
+	// are ignored.
+	Fallback bool
+	// Format output format, "json" or a pattern see WriterHandler.SetFormat, empty means default.
+	Format string
+	// RotateSize max size of a file, 0 means default, see filewriter.MaxSize.
+	RotateSize int64
+	// MaxLogFile max number of rotated files, 0 means default, see filewriter.MaxFile.
+	MaxLogFile int
+	// Options the other filewriter options, e.g. filewriter.RotateFormat.
+	Options []filewriter.Option
+}
 
-var _fileNames = map[int]string{
-	_infoIdx:  "info.log",
-	_warnIdx:  "warning.log",
-	_errorIdx: "error.log",
+// fileRoute is a parsed FileRoute.
+type fileRoute struct {
+	// enc is the index of the encoder in fileRouting.encs, the routes of the same format
+	// share the encoder so an entry is rendered once.
+	enc          int
+	min, max     Level
+	field, value string
+	stop         bool
+	fallback     bool
+	fw           *filewriter.FileWriter
 }
 
-// FileHandler .
+// match reports whether the entry of lv and fields matches the route.
+func (r *fileRoute) match(lv Level, args, bound []D) bool {
+	if lv < r.min || lv > r.max {
+		return false
+	}
+	if r.field == "" {
+		return true
+	}
+	for _, fields := range [][]D{args, bound} {
+		for _, f := range fields {
+			if f.Key == r.field && (r.value == "" || fieldString(f) == r.value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// FileHandler writes entries to the log files by routes, see FileRoute.
 type FileHandler struct {
-	render Render
-	fws    [_totalIdx]*filewriter.FileWriter //filewriter.FileWriter实现了Write，所以可以用io.writer指向它
+	fields []D
+	level  *atomicLevel
+	// rt holds a *fileRouting, it's replaced by SetFormat while other goroutines are logging.
+	rt  atomic.Value
+	fws []*filewriter.FileWriter //filewriter.FileWriter实现了Write，所以可以用io.writer指向它
 }
 
-// NewFile crete a file logger.
+// fileRouting is the routes and the encoders they share.
+type fileRouting struct {
+	encs   []entryEncoder
+	routes []fileRoute
+}
+
+// routing returns the current routes.
+func (h *FileHandler) routing() *fileRouting {
+	return h.rt.Load().(*fileRouting)
+}
+
+// NewFile crete a file logger handles all levels, it panics if the log files can't be created.
+// The entries go to info.log, warning.log and error.log by level, see SetLevel for the
+// minimum level.
 func NewFile(dir string, bufferSize, rotateSize int64, maxLogFile int) *FileHandler {
-	// new info writer
-	newWriter := func(name string) *filewriter.FileWriter {
-		var options []filewriter.Option
-		if rotateSize > 0 {
-			options = append(options, filewriter.MaxSize(rotateSize))
+	handler, err := newFile(dir, bufferSize, rotateSize, maxLogFile)
+	if err != nil {
+		panic(err)
+	}
+	return handler
+}
+
+func newFile(dir string, bufferSize, rotateSize int64, maxLogFile int) (*FileHandler, error) {
+	routes := make([]FileRoute, len(_fileRoutes))
+	for i, r := range _fileRoutes {
+		r.RotateSize, r.MaxLogFile = rotateSize, maxLogFile
+		routes[i] = r
+	}
+	return newFileRoutes(dir, routes)
+}
+
+// NewFileRoutes create a file logger routes the entries by routes in order, it panics if
+// the routes are invalid or the log files can't be created.
+func NewFileRoutes(dir string, routes []FileRoute) *FileHandler {
+	handler, err := newFileRoutes(dir, routes)
+	if err != nil {
+		panic(err)
+	}
+	return handler
+}
+
+func newFileRoutes(dir string, routes []FileRoute) (*FileHandler, error) {
+	if len(routes) == 0 {
+		return nil, fmt.Errorf("log: no file routes")
+	}
+	handler := &FileHandler{level: newAtomicLevel(_debugLevel)}
+	rt := &fileRouting{}
+	files := make(map[string]*filewriter.FileWriter)
+	encs := make(map[string]int)
+	for _, r := range routes {
+		if r.Name == "" {
+			return nil, handler.closeErr(fmt.Errorf("log: empty file route name"))
+		}
+		route := fileRoute{min: _debugLevel, max: _fatalLevel, field: r.Field, value: r.Value, stop: r.Stop, fallback: r.Fallback}
+		var ok bool
+		if r.MinLevel != "" {
+			if route.min, ok = parseLevel(r.MinLevel); !ok {
+				return nil, handler.closeErr(fmt.Errorf("log: unknown level %q of file route %s", r.MinLevel, r.Name))
+			}
+		}
+		if r.MaxLevel != "" {
+			if route.max, ok = parseLevel(r.MaxLevel); !ok {
+				return nil, handler.closeErr(fmt.Errorf("log: unknown level %q of file route %s", r.MaxLevel, r.Name))
+			}
 		}
-		if maxLogFile > 0 {
-			options = append(options, filewriter.MaxFile(maxLogFile))
+		format := r.Format
+		if format == "" {
+			format = _filePattern
 		}
-		w, err := filewriter.New(filepath.Join(dir, name), options...)
-		if err != nil {
-			panic(err)
+		idx, ok := encs[format]
+		if !ok {
+			idx = len(rt.encs)
+			encs[format] = idx
+			rt.encs = append(rt.encs, newEntryEncoder(format, nil))
 		}
-		return w
+		route.enc = idx
+		if route.fw = files[r.Name]; route.fw == nil {
+			var options []filewriter.Option
+			if r.RotateSize > 0 {
+				options = append(options, filewriter.MaxSize(r.RotateSize))
+			}
+			if r.MaxLogFile > 0 {
+				options = append(options, filewriter.MaxFile(r.MaxLogFile))
+			}
+			w, err := filewriter.New(filepath.Join(dir, r.Name), append(options, r.Options...)...)
+			if err != nil {
+				return nil, handler.closeErr(err)
+			}
+			files[r.Name] = w
+			handler.fws = append(handler.fws, w)
+			route.fw = w
+		}
+		rt.routes = append(rt.routes, route)
 	}
-	handler := &FileHandler{
-		render: newPatternRender("[%D %T] [%L] [%S] %M\n"),
+	handler.rt.Store(rt)
+	return handler, nil
+}
+
+// closeErr closes the files created and returns err.
+func (h *FileHandler) closeErr(err error) error {
+	h.Close()
+	return err
+}
+
+// Log loggint to file, the entry is written to every file of the routes matched once,
+// and it's rendered once per format.
+func (h *FileHandler) Log(ctx context.Context, lv Level, args ...D) {
+	var (
+		rt      = h.routing()
+		written [4]*filewriter.FileWriter
+		ws      = written[:0]
+		matched bool
+		bufs    = make([]*core.Buffer, len(rt.encs))
+	)
+	write := func(r *fileRoute) {
+		for _, w := range ws {
+			if w == r.fw {
+				return
+			}
+		}
+		ws = append(ws, r.fw)
+		if bufs[r.enc] == nil {
+			bufs[r.enc] = core.GetPool()
+			if err := rt.encs[r.enc].Encode(bufs[r.enc], args); err != nil {
+				bufs[r.enc].Reset()
+			}
+		}
+		if buf := bufs[r.enc]; buf.Len() != 0 {
+			r.fw.Write(buf.Bytes())
+		}
 	}
-	for idx, name := range _fileNames {
-		handler.fws[idx] = newWriter(name)
+	defer func() {
+		for _, buf := range bufs {
+			if buf != nil {
+				buf.Free()
+			}
+		}
+	}()
+	for i := range rt.routes {
+		r := &rt.routes[i]
+		if r.fallback || !r.match(lv, args, h.fields) {
+			continue
+		}
+		matched = true
+		write(r)
+		if r.stop {
+			break
+		}
+	}
+	if matched {
+		return
+	}
+	for i := range rt.routes {
+		if rt.routes[i].fallback {
+			write(&rt.routes[i])
+		}
 	}
-	return handler
 }
 
-// Log loggint to file .
-func (h *FileHandler) Log(ctx context.Context, lv Level, args ...D) {
-	d := toMap(args...)
-	// add extra fields
-	addExtraField(ctx, d)
-	d[_time] = time.Now().Format(_timeFormat)
-	var w io.Writer
-	switch lv {
-	case _warnLevel:
-		w = h.fws[_warnIdx]
-	case _errorLevel:
-		w = h.fws[_errorIdx]
-	default:
-		w = h.fws[_infoIdx]
+// With returns a file handler shares the log files and attaches fields to every entry.
+func (h *FileHandler) With(fields ...D) Handler {
+	bound := append(h.fields[:len(h.fields):len(h.fields)], fields...)
+	rt := h.routing()
+	encs := make([]entryEncoder, len(rt.encs))
+	for i, enc := range rt.encs {
+		encs[i] = enc.With(fields)
 	}
-	h.render.Render(w, d)
+	child := &FileHandler{fields: bound, level: h.level, fws: h.fws}
+	child.rt.Store(&fileRouting{encs: encs, routes: rt.routes})
+	return child
+}
+
+// SetLevel set the minimum level of file handler.
+func (h *FileHandler) SetLevel(lv Level) {
+	h.level.SetLevel(lv)
+}
+
+// Enabled reports whether lv is at or above the minimum level.
+func (h *FileHandler) Enabled(lv Level) bool {
+	return h.level.Enabled(lv)
 }
 
 // Close log handler
 func (h *FileHandler) Close() error {
 	for _, fw := range h.fws {
+		if fw == nil {
+			continue
+		}
 		// ignored error
 		fw.Close()
 	}
 	return nil
 }
 
-// SetFormat set log format
+// SetFormat set log format of all routes, a pattern see WriterHandler.SetFormat or "json"
+// for JSON lines. It's safe to call while other goroutines are logging.
 func (h *FileHandler) SetFormat(format string) {
-	h.render = newPatternRender(format + "\n")
+	rt := h.routing()
+	routes := make([]fileRoute, len(rt.routes))
+	for i, r := range rt.routes {
+		r.enc = 0
+		routes[i] = r
+	}
+	h.rt.Store(&fileRouting{encs: []entryEncoder{newEntryEncoder(format, h.fields)}, routes: routes})
 }
@@ -132,7 +132,8 @@ func (f *FileWriter) daemon() {
 				continue
 			}
 		}
-		if f.writer.Buffered() != 0 || atomic.LoadInt32(&f.closed) != 1 {
+		// drain pending logs before exit, Close must not lose them.
+		if len(f.ch) != 0 || f.writer.Buffered() != 0 || atomic.LoadInt32(&f.closed) != 1 {
 			continue
 		}
 
@@ -0,0 +1,373 @@
+package log
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	stdlog "log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hxchjm/log/core"
+)
+
+const (
+	_fluentTimeout = 3 * time.Second
+	_fluentChan    = 2048
+	_fluentBatch   = 100
+)
+
+// FluentConfig fluentd handler config, see NewFluent.
+type FluentConfig struct {
+	// Network of fluentd or fluent-bit, tcp or unix.
+	Network string
+	// Addr host:port or socket path of the forward input, e.g. 127.0.0.1:24224.
+	Addr string
+	// Tag prefix of the entries, the tag is Tag.level e.g. main.app.info,
+	// empty means Config.Family.
+	Tag string
+	// Batch max number of the entries of a Forward mode message, 1 means Message mode,
+	// 0 means 100.
+	Batch int
+	// Interval the entries are sent at least once per Interval, 0 means 1s.
+	Interval time.Duration
+	// Ack requires fluentd to ack every message, it's resent until acked.
+	Ack bool
+	// Chan size of the entry queue, entries are dropped when it's full, 0 means 2048.
+	Chan int
+	// Timeout of dialing, writing and waiting for the ack.
+	Timeout time.Duration
+}
+
+// FluentHandler sends entries to fluentd by the forward protocol, the record is
+// the fields of the entry.
+type FluentHandler struct {
+	fields []D
+	level  *atomicLevel
+	f      *fluent
+}
+
+// fluentEntry is a queued entry, buf is the msgpack of its time and record.
+type fluentEntry struct {
+	tag string
+	buf *core.Buffer
+}
+
+// fluentMessage is a packed message waiting to be sent, chunk is the chunk id of its
+// option if ack is required.
+type fluentMessage struct {
+	b     []byte
+	chunk string
+}
+
+// fluentBatch is the entries of a tag.
+type fluentBatch struct {
+	entries *core.Buffer
+	count   int
+}
+
+// fluent is the connection to fluentd shared by a FluentHandler and its children.
+type fluent struct {
+	c      FluentConfig
+	stdlog *stdlog.Logger
+
+	ch      chan fluentEntry
+	done    chan struct{}
+	once    sync.Once
+	waiter  sync.WaitGroup
+	dropped uint64
+
+	nc *netConn
+	// unsent is the messages failed to send, which are resent first, it's only used by proc.
+	unsent      []fluentMessage
+	unsentBytes int
+}
+
+// NewFluent create a fluentd handler handles all levels, it panics if the config is invalid.
+func NewFluent(conf *FluentConfig) *FluentHandler {
+	handler, err := newFluent(conf)
+	if err != nil {
+		panic(err)
+	}
+	return handler
+}
+
+func newFluent(conf *FluentConfig) (*FluentHandler, error) {
+	if conf == nil {
+		return nil, fmt.Errorf("log: nil fluent config")
+	}
+	c := *conf
+	switch c.Network {
+	case "tcp", "tcp4", "tcp6", "unix":
+	default:
+		return nil, fmt.Errorf("log: unknown fluent network %q", c.Network)
+	}
+	if c.Addr == "" {
+		return nil, fmt.Errorf("log: empty fluent address")
+	}
+	if c.Batch <= 0 {
+		c.Batch = _fluentBatch
+	}
+	if c.Interval <= 0 {
+		c.Interval = _mergeWait
+	}
+	if c.Chan <= 0 {
+		c.Chan = _fluentChan
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = _fluentTimeout
+	}
+	f := &fluent{
+		c:      c,
+		stdlog: stdlog.New(os.Stderr, "log-fluent ", stdlog.LstdFlags),
+		ch:     make(chan fluentEntry, c.Chan),
+		done:   make(chan struct{}),
+		nc: newNetConn("log-fluent ", c.Timeout, func() (net.Conn, error) {
+			return net.DialTimeout(c.Network, c.Addr, c.Timeout)
+		}),
+	}
+	f.waiter.Add(1)
+	go f.proc()
+	return &FluentHandler{level: newAtomicLevel(_debugLevel), f: f}, nil
+}
+
+// Log queues the entry, it's dropped if the queue is full.
+func (h *FluentHandler) Log(ctx context.Context, lv Level, args ...D) {
+	tag := h.f.c.Tag
+	ts := time.Now()
+	record := make([]D, 0, len(args)+len(h.fields))
+	for _, f := range args {
+		switch f.Key {
+		case _time:
+			if t, ok := f.Value.(time.Time); ok {
+				ts = t
+			}
+		case _levelValue:
+		case _appID:
+			if tag == "" {
+				tag = f.StringVal
+			}
+			record = append(record, f)
+		default:
+			record = append(record, f)
+		}
+	}
+	n := len(record)
+	for _, f := range h.fields {
+		if !hasKey(record[:n], f.Key) {
+			record = append(record, f)
+		}
+	}
+	if tag == "" {
+		tag = "log"
+	}
+	buf := core.GetPool()
+	appendMsgpackEventTime(buf, ts)
+	appendMsgpackMap(buf, len(record))
+	for _, f := range record {
+		appendMsgpackString(buf, f.Key)
+		appendMsgpackField(buf, f)
+	}
+	e := fluentEntry{tag: tag + "." + strings.ToLower(lv.String()), buf: buf}
+	select {
+	case <-h.f.done:
+	default:
+		select {
+		case h.f.ch <- e:
+			return
+		default:
+		}
+	}
+	buf.Free()
+	atomic.AddUint64(&h.f.dropped, 1)
+}
+
+// With returns a fluentd handler shares the connection and attaches fields to every entry.
+func (h *FluentHandler) With(fields ...D) Handler {
+	bound := append(h.fields[:len(h.fields):len(h.fields)], fields...)
+	return &FluentHandler{fields: bound, level: h.level, f: h.f}
+}
+
+// SetLevel set the minimum level of fluentd handler.
+func (h *FluentHandler) SetLevel(lv Level) {
+	h.level.SetLevel(lv)
+}
+
+// Enabled reports whether lv is at or above the minimum level.
+func (h *FluentHandler) Enabled(lv Level) bool {
+	return h.level.Enabled(lv)
+}
+
+// Dropped returns the number of the entries dropped because the queue is full, and the
+// messages dropped because the unsent messages are full or fluentd is down on close.
+func (h *FluentHandler) Dropped() uint64 {
+	return atomic.LoadUint64(&h.f.dropped)
+}
+
+// Close sends the queued entries and closes the connection.
+func (h *FluentHandler) Close() error {
+	h.f.once.Do(func() {
+		close(h.f.done)
+	})
+	h.f.waiter.Wait()
+	return nil
+}
+
+// SetFormat is ignored, the records are always msgpack.
+func (h *FluentHandler) SetFormat(string) {}
+
+// proc batches the entries by tag and sends them.
+func (f *fluent) proc() {
+	defer f.waiter.Done()
+	var (
+		batches = make(map[string]*fluentBatch)
+		count   int
+	)
+	flush := func() {
+		for tag, b := range batches {
+			if b.count > 0 {
+				f.pack(tag, b)
+			}
+			b.entries.Free()
+			delete(batches, tag)
+		}
+		count = 0
+		f.sendUnsent()
+	}
+	add := func(e fluentEntry) {
+		defer e.buf.Free()
+		if f.c.Batch == 1 {
+			msg := core.GetPool()
+			if f.c.Ack {
+				appendMsgpackArray(msg, 4)
+			} else {
+				appendMsgpackArray(msg, 3)
+			}
+			appendMsgpackString(msg, e.tag)
+			msg.Write(e.buf.Bytes())
+			f.queue(msg, 1)
+			f.sendUnsent()
+			return
+		}
+		b, ok := batches[e.tag]
+		if !ok {
+			b = &fluentBatch{entries: core.GetPool()}
+			batches[e.tag] = b
+		}
+		b.entries.AppendByte(0x92)
+		b.entries.Write(e.buf.Bytes())
+		b.count++
+		if count++; count >= f.c.Batch || b.entries.Len() >= _maxBuffer {
+			flush()
+		}
+	}
+	tick := time.NewTicker(f.c.Interval)
+	defer tick.Stop()
+	for {
+		select {
+		case e := <-f.ch:
+			add(e)
+		case <-tick.C:
+			flush()
+		case <-f.done:
+			for len(f.ch) != 0 {
+				add(<-f.ch)
+			}
+			// try once more regardless of the backoff.
+			f.nc.retryNow()
+			flush()
+			if len(f.unsent) != 0 {
+				f.stdlog.Printf("%d unsent messages are dropped on close\n", len(f.unsent))
+				atomic.AddUint64(&f.dropped, uint64(len(f.unsent)))
+			}
+			f.nc.close()
+			return
+		}
+	}
+}
+
+// pack packs the batch of tag into a Forward mode message [tag, [[time, record]...], option]
+// and queues it to send.
+func (f *fluent) pack(tag string, b *fluentBatch) {
+	msg := core.GetPool()
+	if f.c.Ack {
+		appendMsgpackArray(msg, 3)
+	} else {
+		appendMsgpackArray(msg, 2)
+	}
+	appendMsgpackString(msg, tag)
+	appendMsgpackArray(msg, b.count)
+	msg.Write(b.entries.Bytes())
+	f.queue(msg, b.count)
+}
+
+// queue appends the option of msg, a Message mode [tag, time, record, option] or Forward
+// mode message without the option, and queues it, the oldest unsent messages are
+// dropped beyond the max buffer.
+func (f *fluent) queue(msg *core.Buffer, size int) {
+	var m fluentMessage
+	if f.c.Ack {
+		m.chunk = fluentChunkID()
+		appendMsgpackMap(msg, 2)
+		appendMsgpackString(msg, "chunk")
+		appendMsgpackString(msg, m.chunk)
+		appendMsgpackString(msg, "size")
+		appendMsgpackInt(msg, int64(size))
+	}
+	m.b = append([]byte(nil), msg.Bytes()...)
+	msg.Free()
+	f.unsent = append(f.unsent, m)
+	f.unsentBytes += len(m.b)
+	for f.unsentBytes > _maxBuffer && len(f.unsent) > 1 {
+		f.stdlog.Printf("unsent messages exceed %d bytes, the oldest is dropped\n", _maxBuffer)
+		f.unsentBytes -= len(f.unsent[0].b)
+		f.unsent = f.unsent[1:]
+		atomic.AddUint64(&f.dropped, 1)
+	}
+}
+
+// sendUnsent sends the unsent messages in order, it stops at the first failure, the
+// connection waits for the backoff before redialing.
+func (f *fluent) sendUnsent() {
+	for len(f.unsent) != 0 {
+		m := f.unsent[0]
+		if err := f.nc.sendMsg(m.b, f.frame(m.chunk)); err != nil {
+			return
+		}
+		f.unsentBytes -= len(m.b)
+		f.unsent[0] = fluentMessage{}
+		f.unsent = f.unsent[1:]
+	}
+}
+
+// frame returns the frame of the message of chunk, which writes the message and waits
+// for the ack of chunk if required.
+func (f *fluent) frame(chunk string) func(conn net.Conn, msg []byte) error {
+	return func(conn net.Conn, msg []byte) error {
+		if _, err := conn.Write(msg); err != nil || chunk == "" {
+			return err
+		}
+		conn.SetReadDeadline(time.Now().Add(f.c.Timeout))
+		resp, err := decodeMsgpack(bufio.NewReaderSize(conn, 64))
+		if err != nil {
+			return err
+		}
+		if m, ok := resp.(map[string]interface{}); !ok || m["ack"] != chunk {
+			return fmt.Errorf("unexpected ack %v", resp)
+		}
+		return nil
+	}
+}
+
+// fluentChunkID returns a unique chunk id of a message.
+func fluentChunkID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return base64.StdEncoding.EncodeToString(b[:])
+}
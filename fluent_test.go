@@ -0,0 +1,185 @@
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hxchjm/log/core"
+	"github.com/stretchr/testify/assert"
+)
+
+// serveFluent decodes the messages of the first connection of ln and acks them if required.
+func serveFluent(ln net.Listener) <-chan []interface{} {
+	ch := make(chan []interface{}, 16)
+	go func() {
+		defer close(ch)
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			v, err := decodeMsgpack(r)
+			if err != nil {
+				return
+			}
+			msg := v.([]interface{})
+			opt, _ := msg[len(msg)-1].(map[string]interface{})
+			if chunk, ok := opt["chunk"].(string); ok {
+				buf := core.GetPool()
+				appendMsgpackMap(buf, 1)
+				appendMsgpackString(buf, "ack")
+				appendMsgpackString(buf, chunk)
+				conn.Write(buf.Bytes())
+				buf.Free()
+			}
+			ch <- msg
+		}
+	}()
+	return ch
+}
+
+func TestMsgpack(t *testing.T) {
+	now := time.Unix(1600000000, 123)
+	buf := core.GetPool()
+	defer buf.Free()
+	appendMsgpackValue(buf, []interface{}{int64(-1), int64(-200), uint64(70000), "a", 1.5, true, nil, map[string]string{"k": "v"}})
+	appendMsgpackEventTime(buf, now)
+	r := bufio.NewReader(bytes.NewReader(buf.Bytes()))
+	v, err := decodeMsgpack(r)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{int64(-1), int64(-200), uint64(70000), "a", 1.5, true, nil, map[string]interface{}{"k": "v"}}, v)
+	v, err = decodeMsgpack(r)
+	assert.NoError(t, err)
+	assert.True(t, now.Equal(v.(time.Time)))
+
+	// a huge length from the network is rejected instead of allocated.
+	for _, b := range [][]byte{{0xdb, 0xff, 0xff, 0xff, 0xff}, {0xdd, 0xff, 0xff, 0xff, 0xff}, {0xdf, 0xff, 0xff, 0xff, 0xff}} {
+		_, err = decodeMsgpack(bufio.NewReader(bytes.NewReader(b)))
+		assert.Error(t, err)
+	}
+}
+
+func TestFluentHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fluent")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	addr := filepath.Join(dir, "fluent.sock")
+	ln, err := net.Listen("unix", addr)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer ln.Close()
+	msgs := serveFluent(ln)
+
+	fh, err := newFluent(&FluentConfig{Network: "unix", Addr: addr, Ack: true})
+	if !assert.NoError(t, err) {
+		return
+	}
+	l := newLogger(&Config{Family: "main.app", Debug: true}, newHandlers(nil, fh))
+	l.Info("a")
+	l.With(KVInt("uid", 1)).Info("b")
+	l.Error("c")
+	assert.NoError(t, l.Close())
+
+	forward := map[string][]interface{}{}
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-msgs:
+			if assert.Len(t, msg, 3) {
+				forward[msg[0].(string)] = msg[1].([]interface{})
+				assert.Equal(t, int64(len(msg[1].([]interface{}))), msg[2].(map[string]interface{})["size"])
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timeout")
+		}
+	}
+	if assert.Len(t, forward["main.app.info"], 2) {
+		a := forward["main.app.info"][0].([]interface{})
+		assert.IsType(t, time.Time{}, a[0])
+		assert.Equal(t, "a", a[1].(map[string]interface{})[_log])
+		assert.Equal(t, "main.app", a[1].(map[string]interface{})[_appID])
+		b := forward["main.app.info"][1].([]interface{})[1].(map[string]interface{})
+		assert.Equal(t, "b", b[_log])
+		assert.Equal(t, int64(1), b["uid"])
+	}
+	if assert.Len(t, forward["main.app.error"], 1) {
+		assert.Equal(t, "c", forward["main.app.error"][0].([]interface{})[1].(map[string]interface{})[_log])
+	}
+	assert.Equal(t, uint64(0), fh.Dropped())
+}
+
+func TestFluentMessageMode(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer ln.Close()
+	msgs := serveFluent(ln)
+
+	fh, _ := newFluent(&FluentConfig{Network: "tcp", Addr: ln.Addr().String(), Tag: "svc", Batch: 1})
+	l := newLogger(&Config{Family: "main.app"}, newHandlers(nil, fh))
+	l.Warn("w")
+	select {
+	case msg := <-msgs:
+		if assert.Len(t, msg, 3) {
+			assert.Equal(t, "svc.warn", msg[0])
+			assert.IsType(t, time.Time{}, msg[1])
+			assert.Equal(t, "w", msg[2].(map[string]interface{})[_log])
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+	assert.NoError(t, l.Close())
+
+	_, err = newFluent(&FluentConfig{Network: "udp", Addr: "127.0.0.1:24224"})
+	assert.Error(t, err)
+}
+
+func TestFluentReconnect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fluent")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	addr := filepath.Join(dir, "fluent.sock")
+	fh, err := newFluent(&FluentConfig{Network: "unix", Addr: addr, Tag: "svc", Batch: 1, Ack: true})
+	if !assert.NoError(t, err) {
+		return
+	}
+	clock := newFakeClock()
+	fh.f.nc.now = clock.Now
+	l := newLogger(&Config{}, newHandlers(nil, fh))
+	l.Info("down")
+
+	ln, err := net.Listen("unix", addr)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer ln.Close()
+	msgs := serveFluent(ln)
+	// the unsent message is resent in order once the backoff is over.
+	clock.Add(_agentBackoffMax)
+	l.Info("up")
+	for _, want := range []string{"down", "up"} {
+		select {
+		case msg := <-msgs:
+			if assert.Len(t, msg, 4) {
+				assert.Equal(t, want, msg[2].(map[string]interface{})[_log])
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout")
+		}
+	}
+	assert.NoError(t, l.Close())
+	assert.Equal(t, uint64(0), fh.Dropped())
+}
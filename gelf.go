@@ -0,0 +1,273 @@
+package log
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/hxchjm/log/core"
+	"github.com/hxchjm/log/env"
+)
+
+// compressions of GELFConfig.
+const (
+	GELFGzip = "gzip"
+	GELFZlib = "zlib"
+)
+
+const (
+	_gelfTimeout   = time.Second
+	_gelfChunkSize = 1420
+	// _gelfChunkHead is the size of the chunk header: magic, message id, sequence number and count.
+	_gelfChunkHead = 12
+	_gelfMaxChunks = 128
+)
+
+// GELFConfig GELF handler config, see NewGELF.
+type GELFConfig struct {
+	// Network of the Graylog input, udp or tcp.
+	Network string
+	// Addr host:port of the Graylog input.
+	Addr string
+	// Compress of the udp messages, gzip or zlib, empty means none,
+	// the tcp messages are never compressed.
+	Compress string
+	// ChunkSize max size of a udp datagram, the larger messages are chunked,
+	// 0 means 1420, which fits the WAN MTU.
+	ChunkSize int
+	// Timeout of dialing and writing.
+	Timeout time.Duration
+}
+
+// GELFHandler sends entries as GELF 1.1 messages to Graylog, the level is the syslog
+// severity, the log field is the short_message and full_message, the other fields
+// are the additional fields prefixed by _. The messages are written to stderr while
+// Graylog is down.
+type GELFHandler struct {
+	// enc holds the additional fields bound by With.
+	enc   boundEncoder
+	level *atomicLevel
+	c     *GELFConfig
+	nc    *netConn
+}
+
+// NewGELF create a GELF handler handles all levels, it panics if the config is invalid,
+// Graylog is dialed on the first entry.
+func NewGELF(conf *GELFConfig) *GELFHandler {
+	handler, err := newGELF(conf)
+	if err != nil {
+		panic(err)
+	}
+	return handler
+}
+
+func newGELF(conf *GELFConfig) (*GELFHandler, error) {
+	if conf == nil {
+		return nil, fmt.Errorf("log: nil gelf config")
+	}
+	c := *conf
+	switch c.Network {
+	case "udp", "udp4", "udp6", "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("log: unknown gelf network %q", c.Network)
+	}
+	if c.Addr == "" {
+		return nil, fmt.Errorf("log: empty gelf address")
+	}
+	switch c.Compress {
+	case "", GELFGzip, GELFZlib:
+	default:
+		return nil, fmt.Errorf("log: unknown gelf compression %q", c.Compress)
+	}
+	if c.ChunkSize <= 0 {
+		c.ChunkSize = _gelfChunkSize
+	}
+	if c.ChunkSize <= _gelfChunkHead {
+		return nil, fmt.Errorf("log: too small gelf chunk size %d", c.ChunkSize)
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = _gelfTimeout
+	}
+	return &GELFHandler{
+		enc:   newBoundEncoder(),
+		level: newAtomicLevel(_debugLevel),
+		c:     &c,
+		nc: newNetConn("log-gelf ", c.Timeout, func() (net.Conn, error) {
+			return net.DialTimeout(c.Network, c.Addr, c.Timeout)
+		}),
+	}, nil
+}
+
+// Log sends the entry to Graylog.
+func (h *GELFHandler) Log(ctx context.Context, lv Level, args ...D) {
+	var (
+		host  = env.Hostname
+		msg   string
+		stack string
+		ts    = time.Now()
+	)
+	fields := make([]D, 0, len(args))
+	for _, f := range args {
+		switch f.Key {
+		case _instanceID:
+			host = f.StringVal
+		case _log:
+			msg = f.StringVal
+		case _stack:
+			stack = f.StringVal
+		case _time:
+			if t, ok := f.Value.(time.Time); ok {
+				ts = t
+			}
+		case _level, _levelValue:
+		default:
+			fields = append(fields, gelfField(f))
+		}
+	}
+	short, full := msg, ""
+	if i := strings.IndexByte(msg, '\n'); i >= 0 {
+		short, full = msg[:i], msg
+	}
+	if stack != "" {
+		full = msg + "\n" + stack
+	}
+	if short == "" {
+		short = "-"
+	}
+	if host == "" {
+		host = "-"
+	}
+	head := []D{
+		KVString("version", "1.1"),
+		KVString("host", host),
+		KVString("short_message", short),
+	}
+	if full != "" {
+		head = append(head, KVString("full_message", full))
+	}
+	head = append(head,
+		KVFloat64("timestamp", float64(ts.UnixNano())/1e9),
+		KVInt("level", syslogSeverity(lv)),
+	)
+	buf := core.GetPool()
+	if err := h.enc.encode(buf, head, fields); err == nil {
+		buf.TrimNewline()
+		h.nc.write(buf.Bytes(), h.frame)
+	}
+	buf.Free()
+}
+
+// With returns a GELF handler shares the connection and attaches fields to every entry.
+func (h *GELFHandler) With(fields ...D) Handler {
+	bound := make([]D, 0, len(fields))
+	for _, f := range fields {
+		if isInternalKey(f.Key) || f.Key == _log {
+			continue
+		}
+		bound = append(bound, gelfField(f))
+	}
+	return &GELFHandler{enc: h.enc.with(bound), level: h.level, c: h.c, nc: h.nc}
+}
+
+// SetLevel set the minimum level of GELF handler.
+func (h *GELFHandler) SetLevel(lv Level) {
+	h.level.SetLevel(lv)
+}
+
+// Enabled reports whether lv is at or above the minimum level.
+func (h *GELFHandler) Enabled(lv Level) bool {
+	return h.level.Enabled(lv)
+}
+
+// Close closes the connection.
+func (h *GELFHandler) Close() error {
+	return h.nc.close()
+}
+
+// SetFormat is ignored, the messages are always GELF.
+func (h *GELFHandler) SetFormat(string) {}
+
+// frame writes msg null-byte delimited on tcp, or compressed and chunked on udp.
+func (h *GELFHandler) frame(conn net.Conn, msg []byte) error {
+	if isStream(conn) {
+		_, err := conn.Write(append(msg, 0))
+		return err
+	}
+	data, err := h.compress(msg)
+	if err != nil {
+		return err
+	}
+	if len(data) <= h.c.ChunkSize {
+		_, err = conn.Write(data)
+		return err
+	}
+	size := h.c.ChunkSize - _gelfChunkHead
+	count := (len(data) + size - 1) / size
+	if count > _gelfMaxChunks {
+		return fmt.Errorf("gelf message of %d bytes exceeds %d chunks", len(data), _gelfMaxChunks)
+	}
+	chunk := make([]byte, h.c.ChunkSize)
+	chunk[0], chunk[1] = 0x1e, 0x0f
+	if _, err = rand.Read(chunk[2:10]); err != nil {
+		return err
+	}
+	chunk[11] = byte(count)
+	for i := 0; i < count; i++ {
+		chunk[10] = byte(i)
+		n := copy(chunk[_gelfChunkHead:], data[i*size:])
+		if _, err = conn.Write(chunk[:_gelfChunkHead+n]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *GELFHandler) compress(msg []byte) ([]byte, error) {
+	var (
+		buf bytes.Buffer
+		w   io.WriteCloser
+	)
+	switch h.c.Compress {
+	case GELFGzip:
+		w = gzip.NewWriter(&buf)
+	case GELFZlib:
+		w = zlib.NewWriter(&buf)
+	default:
+		return msg, nil
+	}
+	if _, err := w.Write(msg); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// gelfField returns f as an additional field, the key is prefixed by _ and the value
+// is a number or string.
+func gelfField(f D) D {
+	key := "_" + strings.Map(func(r rune) rune {
+		if r == '.' || r == '-' || r == '_' || (r >= '0' && r <= '9') || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') {
+			return r
+		}
+		return '_'
+	}, f.Key)
+	if key == "_id" {
+		// _id is reserved by Graylog.
+		key = "_id_"
+	}
+	switch f.Type {
+	case core.UintType, core.Uint64Type, core.IntTpye, core.Int64Type, core.Float32Type, core.Float64Type, core.DurationType, core.StringType:
+		f.Key = key
+		return f
+	}
+	return KVString(key, fieldString(f))
+}
@@ -0,0 +1,138 @@
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readGELF(t *testing.T, pc net.PacketConn) map[string]interface{} {
+	var (
+		chunks [][]byte
+		b      = make([]byte, 8192)
+	)
+	for {
+		pc.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := pc.ReadFrom(b)
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		p := append([]byte(nil), b[:n]...)
+		if len(p) < 2 || p[0] != 0x1e || p[1] != 0x0f {
+			chunks = append(chunks, p)
+			break
+		}
+		chunks = append(chunks, p[12:])
+		if int(p[10]) == int(p[11])-1 {
+			break
+		}
+	}
+	data := bytes.Join(chunks, nil)
+	if data[0] == 0x1f && data[1] == 0x8b {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		data, _ = ioutil.ReadAll(zr)
+	}
+	var m map[string]interface{}
+	assert.NoError(t, json.Unmarshal(data, &m), string(data))
+	return m
+}
+
+func TestGELFUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer pc.Close()
+	gh, err := newGELF(&GELFConfig{Network: "udp", Addr: pc.LocalAddr().String()})
+	if !assert.NoError(t, err) {
+		return
+	}
+	l := newLogger(&Config{Family: "app", Host: "host-1"}, newHandlers(nil, gh))
+	defer l.Close()
+	l.With(KVInt("id", 7)).Warnv(context.Background(), KVString(_log, "paid\nby card"), KVInt("uid", 1), KV("tags", []string{"a"}))
+
+	m := readGELF(t, pc)
+	assert.Equal(t, "1.1", m["version"])
+	assert.Equal(t, "host-1", m["host"])
+	assert.Equal(t, "paid", m["short_message"])
+	assert.Equal(t, "paid\nby card", m["full_message"])
+	assert.Equal(t, float64(4), m["level"])
+	assert.InDelta(t, float64(time.Now().Unix()), m["timestamp"], 5)
+	assert.Equal(t, "app", m["_app_id"])
+	assert.Equal(t, float64(1), m["_uid"])
+	assert.Equal(t, float64(7), m["_id_"])
+	assert.Equal(t, "[a]", m["_tags"])
+	assert.NotContains(t, m, "_level_value")
+
+	gh, err = newGELF(&GELFConfig{Network: "udp", Addr: pc.LocalAddr().String(), Compress: GELFGzip, ChunkSize: 64})
+	if !assert.NoError(t, err) {
+		return
+	}
+	l = newLogger(&Config{}, newHandlers(nil, gh))
+	defer l.Close()
+	long := strings.Repeat("0123456789", 100)
+	l.Error(long)
+	m = readGELF(t, pc)
+	assert.Equal(t, long, m["short_message"])
+	assert.Equal(t, float64(3), m["level"])
+}
+
+func TestGELFTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer ln.Close()
+	msgs := make(chan string, 4)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			msg, err := r.ReadString(0)
+			if err != nil {
+				return
+			}
+			msgs <- strings.TrimSuffix(msg, "\x00")
+		}
+	}()
+	gh, err := newGELF(&GELFConfig{Network: "tcp", Addr: ln.Addr().String(), Compress: GELFZlib})
+	if !assert.NoError(t, err) {
+		return
+	}
+	l := newLogger(&Config{Debug: true}, newHandlers(nil, gh))
+	defer l.Close()
+	l.Info("first")
+	l.Debug("second")
+	for _, want := range []string{"first", "second"} {
+		select {
+		case msg := <-msgs:
+			var m map[string]interface{}
+			if assert.NoError(t, json.Unmarshal([]byte(msg), &m)) {
+				assert.Equal(t, want, m["short_message"])
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s not received", want)
+		}
+	}
+
+	_, err = newGELF(&GELFConfig{Network: "unix", Addr: "/dev/log"})
+	assert.Error(t, err)
+	_, err = newGELF(&GELFConfig{Network: "udp", Addr: "127.0.0.1:12201", Compress: "lz4"})
+	assert.Error(t, err)
+}
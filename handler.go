@@ -20,6 +20,10 @@ const (
 	// _title = "title"
 	// log file.
 	_source = "source"
+	// function name of the call site.
+	_funcName = "func"
+	// name of the logger, see Logger.Named.
+	_logger = "logger"
 	// common log filed.
 	_log = "log"
 	// app name.
@@ -46,6 +50,8 @@ const (
 	_envColor = "env_color"
 	// cluster.
 	_cluster = "cluster"
+	// goroutine stack traces on fatal.
+	_stack = "stack"
 )
 
 // Handler is used to handle log events, outputting them to
@@ -66,27 +72,111 @@ type Handler interface {
 	Close() error
 }
 
-func newHandlers(filters []string, handlers ...Handler) *Handlers {
-	set := make(map[string]struct{})
-	for _, k := range filters {
-		set[k] = struct{}{}
+// levelHandler is implemented by handlers which have their own minimum level,
+// Handlers skips them for the entries below it before any field converting and rendering.
+type levelHandler interface {
+	Handler
+	// Enabled reports whether the handler handles the entries of lv.
+	Enabled(Level) bool
+}
+
+// handlerEnabled reports whether h handles the entries of lv.
+func handlerEnabled(h Handler, lv Level) bool {
+	if lh, ok := h.(levelHandler); ok {
+		return lh.Enabled(lv)
 	}
-	return &Handlers{filters: set, handlers: handlers}
+	return true
+}
+
+// MinLevel returns a handler which only handles the entries at or above lv,
+// it can be used to set a minimum level on any Handler.
+func MinLevel(h Handler, lv Level) Handler {
+	return &minLevelHandler{Handler: h, level: newAtomicLevel(lv)}
+}
+
+type minLevelHandler struct {
+	Handler
+	level *atomicLevel
+}
+
+// Enabled implement levelHandler.
+func (mh *minLevelHandler) Enabled(lv Level) bool {
+	return mh.level.Enabled(lv) && handlerEnabled(mh.Handler, lv)
+}
+
+// With implement fieldHandler.
+func (mh *minLevelHandler) With(fields ...D) Handler {
+	return &minLevelHandler{Handler: withFields(mh.Handler, fields), level: mh.level}
+}
+
+// fieldHandler is implemented by handlers which encode the fields bound by Logger.With
+// once when the child logger is created, instead of on every entry.
+type fieldHandler interface {
+	Handler
+	// With returns a handler sharing the output of the origin one,
+	// which attaches fields to every entry.
+	With(fields ...D) Handler
+}
+
+// withFields returns a handler of h which attaches fields to every entry.
+func withFields(h Handler, fields []D) Handler {
+	if fh, ok := h.(fieldHandler); ok {
+		return fh.With(fields...)
+	}
+	return &fieldsHandler{Handler: h, fields: fields}
+}
+
+// fieldsHandler attaches fields to every entry for handlers which don't implement fieldHandler.
+type fieldsHandler struct {
+	Handler
+	fields []D
+}
+
+// Log handle log with the bound fields.
+func (fh *fieldsHandler) Log(ctx context.Context, lv Level, d ...D) {
+	args := make([]D, 0, len(fh.fields)+len(d))
+	args = append(args, fh.fields...)
+	args = append(args, d...)
+	fh.Handler.Log(ctx, lv, args...)
+}
+
+// Enabled implement levelHandler.
+func (fh *fieldsHandler) Enabled(lv Level) bool {
+	return handlerEnabled(fh.Handler, lv)
+}
+
+// newHandlers create handlers, nil redactor means nothing to redact.
+func newHandlers(r *redactor, handlers ...Handler) *Handlers {
+	return &Handlers{redactor: r, handlers: handlers}
 }
 
 // Handlers a bundle for hander with filter function.
 type Handlers struct {
-	filters  map[string]struct{}
+	redactor *redactor
 	handlers []Handler
 }
 
+// Enabled reports whether any of the handlers handles the entries of lv.
+func (hs Handlers) Enabled(lv Level) bool {
+	for _, h := range hs.handlers {
+		if handlerEnabled(h, lv) {
+			return true
+		}
+	}
+	return false
+}
+
 // Log handlers logging.
 func (hs Handlers) Log(ctx context.Context, lv Level, d ...D) {
+	if !hs.Enabled(lv) {
+		return
+	}
+	d = extract(ctx, d)
+	if hs.redactor != nil {
+		d = hs.redactor.redact(d)
+	}
 	hasSource := false
 	for i := range d {
-		if _, ok := hs.filters[d[i].Key]; ok {
-			d[i].Value = "***"
-		}
 		if d[i].Key == _source {
 			hasSource = true
 		}
@@ -98,10 +188,27 @@ func (hs Handlers) Log(ctx context.Context, lv Level, d ...D) {
 	}
 	d = append(d, KV(_time, time.Now()), KVInt64(_levelValue, int64(lv)), KVString(_level, lv.String()))
 	for _, h := range hs.handlers { //handlers存储了StdoutHandler和FileHandler.
+		if !handlerEnabled(h, lv) {
+			continue
+		}
 		h.Log(ctx, lv, d...)
 	}
 }
 
+// With returns handlers attach fields to every entry, sensitive fields are filtered once here.
+func (hs Handlers) With(fields ...D) Handler {
+	bound := make([]D, len(fields))
+	copy(bound, fields)
+	if hs.redactor != nil {
+		bound = hs.redactor.redact(bound)
+	}
+	handlers := make([]Handler, 0, len(hs.handlers))
+	for _, h := range hs.handlers {
+		handlers = append(handlers, withFields(h, bound))
+	}
+	return &Handlers{redactor: hs.redactor, handlers: handlers}
+}
+
 // Close close resource.
 func (hs Handlers) Close() (err error) {
 	for _, h := range hs.handlers {
@@ -0,0 +1,277 @@
+package log
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	stdlog "log"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hxchjm/log/core"
+)
+
+const (
+	_httpBatch    = 100
+	_httpBytes    = 1 << 20 // 1mb
+	_httpInterval = time.Second
+	_httpTimeout  = 5 * time.Second
+	_httpRetry    = 3
+	_httpBacklog  = 10000
+)
+
+// HTTPConfig http sink config, see NewHTTP.
+type HTTPConfig struct {
+	// URL the batches are POSTed to.
+	URL string
+	// Header of the requests, e.g. {"Authorization": "Bearer xxx"}.
+	Header map[string]string
+	// Batch max number of the entries of a request, 0 means 100.
+	Batch int
+	// Bytes max size of a request body before compression, 0 means 1mb.
+	Bytes int
+	// Interval the entries are sent at least once per Interval, 0 means 1s.
+	Interval time.Duration
+	// Gzip compresses the request bodies.
+	Gzip bool
+	// Timeout of a request, 0 means 5s.
+	Timeout time.Duration
+	// Retry max retries of a batch on 5xx, 429 and network errors, with an exponential
+	// backoff from 100ms, 0 means 3 and negative means no retry.
+	Retry int
+	// Backlog max number of the entries waiting to be sent, the newest are dropped
+	// beyond it, 0 means 10000.
+	Backlog int
+}
+
+// HTTPHandler sends entries as JSON lines to an http endpoint, they are POSTed in batches
+// of the content type application/x-ndjson.
+type HTTPHandler struct {
+	enc    entryEncoder
+	fields []D
+	level  *atomicLevel
+	s      *httpSink
+}
+
+// httpSink is the backlog and the sender shared by an HTTPHandler and its children.
+type httpSink struct {
+	c      HTTPConfig
+	client *http.Client
+	stdlog *stdlog.Logger
+
+	ch      chan *core.Buffer
+	done    chan struct{}
+	once    sync.Once
+	waiter  sync.WaitGroup
+	dropped uint64
+}
+
+// NewHTTP create an http handler handles all levels, it panics if the config is invalid.
+func NewHTTP(conf *HTTPConfig) *HTTPHandler {
+	handler, err := newHTTP(conf)
+	if err != nil {
+		panic(err)
+	}
+	return handler
+}
+
+func newHTTP(conf *HTTPConfig) (*HTTPHandler, error) {
+	if conf == nil {
+		return nil, fmt.Errorf("log: nil http config")
+	}
+	c := *conf
+	if u, err := url.Parse(c.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("log: invalid http url %q", c.URL)
+	}
+	if c.Batch <= 0 {
+		c.Batch = _httpBatch
+	}
+	if c.Bytes <= 0 {
+		c.Bytes = _httpBytes
+	}
+	if c.Interval <= 0 {
+		c.Interval = _httpInterval
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = _httpTimeout
+	}
+	if c.Retry == 0 {
+		c.Retry = _httpRetry
+	}
+	if c.Backlog <= 0 {
+		c.Backlog = _httpBacklog
+	}
+	s := &httpSink{
+		c:      c,
+		client: &http.Client{Timeout: c.Timeout},
+		stdlog: stdlog.New(os.Stderr, "log-http ", stdlog.LstdFlags),
+		ch:     make(chan *core.Buffer, c.Backlog),
+		done:   make(chan struct{}),
+	}
+	s.waiter.Add(1)
+	go s.proc()
+	return &HTTPHandler{
+		enc:   newEntryEncoder(FormatJSON, nil),
+		level: newAtomicLevel(_debugLevel),
+		s:     s,
+	}, nil
+}
+
+// Log queues the entry, it's dropped if the backlog is full.
+func (h *HTTPHandler) Log(ctx context.Context, lv Level, args ...D) {
+	buf := core.GetPool()
+	if err := h.enc.Encode(buf, args); err != nil {
+		buf.Free()
+		return
+	}
+	select {
+	case <-h.s.done:
+	default:
+		select {
+		case h.s.ch <- buf:
+			return
+		default:
+		}
+	}
+	buf.Free()
+	atomic.AddUint64(&h.s.dropped, 1)
+}
+
+// With returns an http handler shares the backlog and attaches fields to every entry.
+func (h *HTTPHandler) With(fields ...D) Handler {
+	bound := append(h.fields[:len(h.fields):len(h.fields)], fields...)
+	return &HTTPHandler{enc: h.enc.With(fields), fields: bound, level: h.level, s: h.s}
+}
+
+// SetLevel set the minimum level of http handler.
+func (h *HTTPHandler) SetLevel(lv Level) {
+	h.level.SetLevel(lv)
+}
+
+// Enabled reports whether lv is at or above the minimum level.
+func (h *HTTPHandler) Enabled(lv Level) bool {
+	return h.level.Enabled(lv)
+}
+
+// Dropped returns the number of the entries dropped because the backlog is full
+// or the endpoint rejected them.
+func (h *HTTPHandler) Dropped() uint64 {
+	return atomic.LoadUint64(&h.s.dropped)
+}
+
+// Close sends the entries in the backlog.
+func (h *HTTPHandler) Close() error {
+	h.s.once.Do(func() {
+		close(h.s.done)
+	})
+	h.s.waiter.Wait()
+	return nil
+}
+
+// SetFormat is ignored, the endpoint always receives JSON lines.
+func (h *HTTPHandler) SetFormat(string) {}
+
+// proc batches the entries and sends them.
+func (s *httpSink) proc() {
+	defer s.waiter.Done()
+	var (
+		batch = core.NewBuffer(4096)
+		count int
+	)
+	flush := func() {
+		if count == 0 {
+			return
+		}
+		s.send(batch.Bytes(), count)
+		batch.Reset()
+		count = 0
+	}
+	add := func(buf *core.Buffer) {
+		if count > 0 && batch.Len()+buf.Len() > s.c.Bytes {
+			flush()
+		}
+		batch.Write(buf.Bytes())
+		buf.Free()
+		if count++; count >= s.c.Batch || batch.Len() >= s.c.Bytes {
+			flush()
+		}
+	}
+	tick := time.NewTicker(s.c.Interval)
+	defer tick.Stop()
+	for {
+		select {
+		case buf := <-s.ch:
+			add(buf)
+		case <-tick.C:
+			flush()
+		case <-s.done:
+			for len(s.ch) != 0 {
+				add(<-s.ch)
+			}
+			flush()
+			return
+		}
+	}
+}
+
+// send posts the batch of n entries, it retries on 5xx, 429 and network errors.
+func (s *httpSink) send(batch []byte, n int) {
+	body := batch
+	if s.c.Gzip {
+		buf := &bytes.Buffer{}
+		zw := gzip.NewWriter(buf)
+		zw.Write(batch)
+		zw.Close()
+		body = buf.Bytes()
+	}
+	var backoff time.Duration
+	for i := 0; ; i++ {
+		retry, err := s.post(body)
+		if err == nil {
+			return
+		}
+		if !retry || s.c.Retry < 0 || i >= s.c.Retry {
+			s.stdlog.Printf("post %d entries to %s error(%v), dropped\n", n, s.c.URL, err)
+			atomic.AddUint64(&s.dropped, uint64(n))
+			return
+		}
+		backoff = nextBackoff(backoff)
+		time.Sleep(backoff)
+	}
+}
+
+// post posts the body once, it returns whether the error is retryable.
+func (s *httpSink) post(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, s.c.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if s.c.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	for k, v := range s.c.Header {
+		req.Header.Set(k, v)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Errorf("status %s", resp.Status)
+	default:
+		return false, fmt.Errorf("status %s", resp.Status)
+	}
+}
@@ -0,0 +1,87 @@
+package log
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// ndjsonServer records the batches it receives, the first fails requests fail with status.
+type ndjsonServer struct {
+	mu      sync.Mutex
+	batches [][]string
+	fails   int
+	status  int
+}
+
+func (s *ndjsonServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fails > 0 {
+		s.fails--
+		w.WriteHeader(s.status)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer token" || r.Header.Get("Content-Type") != "application/x-ndjson" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body = zr
+	}
+	var batch []string
+	sc := bufio.NewScanner(body)
+	for sc.Scan() {
+		var e map[string]interface{}
+		if json.Unmarshal(sc.Bytes(), &e) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		batch = append(batch, e[_log].(string))
+	}
+	s.batches = append(s.batches, batch)
+}
+
+func TestHTTPHandler(t *testing.T) {
+	ns := &ndjsonServer{fails: 2, status: http.StatusServiceUnavailable}
+	srv := httptest.NewServer(ns)
+	defer srv.Close()
+
+	hh, err := newHTTP(&HTTPConfig{URL: srv.URL, Header: map[string]string{"Authorization": "Bearer token"}, Batch: 2, Gzip: true})
+	if !assert.NoError(t, err) {
+		return
+	}
+	l := newLogger(&Config{}, newHandlers(nil, hh))
+	l.Info("a")
+	l.With(KVInt("uid", 1)).Info("b")
+	l.Info("c")
+	assert.NoError(t, l.Close())
+	assert.Equal(t, [][]string{{"a", "b"}, {"c"}}, ns.batches)
+	assert.Equal(t, uint64(0), hh.Dropped())
+
+	ns.fails, ns.status, ns.batches = 1, http.StatusBadRequest, nil
+	hh, _ = newHTTP(&HTTPConfig{URL: srv.URL, Header: map[string]string{"Authorization": "Bearer token"}})
+	l = newLogger(&Config{}, newHandlers(nil, hh))
+	l.Info("rejected")
+	l.Close()
+	assert.Nil(t, ns.batches)
+	assert.Equal(t, uint64(1), hh.Dropped())
+	l.Info("closed")
+	assert.Equal(t, uint64(2), hh.Dropped())
+
+	_, err = newHTTP(&HTTPConfig{URL: "ftp://example.com"})
+	assert.Error(t, err)
+}
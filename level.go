@@ -1,5 +1,10 @@
 package log
 
+import (
+	"strings"
+	"sync/atomic"
+)
+
 // Level of severity.
 type Level int
 
@@ -13,6 +18,21 @@ const (
 	_warnLevel
 	_errorLevel
 	_fatalLevel
+	// _offLevel is only used as a minimum level to disable all logs.
+	_offLevel
+)
+
+// _noLevel means the minimum level is not overridden.
+const _noLevel Level = -1
+
+// exported log level, e.g. for the minimum level of a handler.
+const (
+	DebugLevel = _debugLevel
+	InfoLevel  = _infoLevel
+	WarnLevel  = _warnLevel
+	ErrorLevel = _errorLevel
+	FatalLevel = _fatalLevel
+	OffLevel   = _offLevel
 )
 
 var levelNames = [...]string{
@@ -21,9 +41,44 @@ var levelNames = [...]string{
 	_warnLevel:  "WARN",
 	_errorLevel: "ERROR",
 	_fatalLevel: "FATAL",
+	_offLevel:   "OFF",
 }
 
 // String implementation.
 func (l Level) String() string {
 	return levelNames[l]
 }
+
+// atomicLevel is a minimum level which can be changed while logging.
+type atomicLevel struct {
+	lv int32
+}
+
+func newAtomicLevel(lv Level) *atomicLevel {
+	return &atomicLevel{lv: int32(lv)}
+}
+
+// Enabled reports whether lv is at or above the minimum level.
+func (al *atomicLevel) Enabled(lv Level) bool {
+	return lv >= al.Level()
+}
+
+// Level returns the minimum level.
+func (al *atomicLevel) Level() Level {
+	return Level(atomic.LoadInt32(&al.lv))
+}
+
+// SetLevel changes the minimum level.
+func (al *atomicLevel) SetLevel(lv Level) {
+	atomic.StoreInt32(&al.lv, int32(lv))
+}
+
+// parseLevel returns the level of name case-insensitively, e.g. "info", "WARN".
+func parseLevel(name string) (Level, bool) {
+	for lv, n := range levelNames {
+		if strings.EqualFold(n, name) {
+			return Level(lv), true
+		}
+	}
+	return _noLevel, false
+}
@@ -3,10 +3,10 @@ package log
 import (
 	"context"
 	"flag"
-	"fmt"
 	"io"
 	"os"
 	"strconv"
+	"sync/atomic"
 
 	//"go-common/library/stat/metric"
 	"github.com/hxchjm/log/env"
@@ -19,6 +19,12 @@ type Config struct {
 
 	// stdout
 	Stdout bool
+	// StdoutLevel minimum level of stdout, e.g. WARN, empty means all levels.
+	StdoutLevel string
+	// StdoutWriter output of stdout, e.g. os.Stdout, nil means os.Stderr.
+	StdoutWriter io.Writer
+	// StdoutFormat output format of stdout, "json" or a pattern see SetFormat, empty means default.
+	StdoutFormat string
 
 	// file
 	Dir string
@@ -28,9 +34,43 @@ type Config struct {
 	MaxLogFile int
 	// RotateSize
 	RotateSize int64
+	// FileLevel minimum level of file, e.g. INFO, empty means all levels.
+	FileLevel string
+	// FileFormat output format of file, "json" for JSON lines or a pattern see SetFormat,
+	// empty means default.
+	FileFormat string
+	// FileRoutes routes the entries to the log files in Dir, e.g. audit.log by a field,
+	// empty means info.log, warning.log and error.log by level. RotateSize, MaxLogFile
+	// and FileFormat are the defaults of the routes, see FileRoute.
+	FileRoutes []FileRoute
+	// FileCascade makes the default routes cascading, info.log receives all entries,
+	// warning.log WARN and above, error.log ERROR and above.
+	FileCascade bool
 
 	// log-agent
-	//Agent *AgentConfig
+	Agent *AgentConfig
+	// Syslog sends entries to a syslog server too, see SyslogConfig.
+	Syslog *SyslogConfig
+	// HTTP sends entries to an http endpoint too, see HTTPConfig.
+	HTTP *HTTPConfig
+	// GELF sends entries to Graylog too, see GELFConfig.
+	GELF *GELFConfig
+	// Fluent sends entries to fluentd or fluent-bit too, see FluentConfig.
+	Fluent *FluentConfig
+	// Handlers are the extra handlers, e.g. NewWriterHandler(os.Stdout, "json") or NewRecorder.
+	Handlers []Handler
+	// Async makes every handler above asynchronous with its own queue, see AsyncConfig.
+	Async *AsyncConfig
+	// Sampler caps the entries of every level and message of every handler above to
+	// survive log storms, see SamplerConfig.
+	Sampler *SamplerConfig
+	// Dedupe collapses the consecutive repeated entries of every handler above into one
+	// with a repeated=N field, see DedupeConfig.
+	Dedupe *DedupeConfig
+
+	// Debug enable debug level logging, Debug* calls are ignored by default.
+	// The level can be changed at runtime, see Logger.SetLevel.
+	Debug bool
 
 	// V Enable V-leveled logging at the specified level.
 	V int32
@@ -43,8 +83,19 @@ type Config struct {
 	//   "dao*" = 2
 	// sets the V level to 2 in all Go files whose names begin "dao".
 	Module map[string]int32
+	// Names overrides the minimum level of the named loggers, see Logger.Named.
+	// A name matches itself and its children, the longest one wins. For instance:
+	// [names]
+	//   "payment" = "WARN"
+	//   "payment.dao" = "OFF"
+	// Level is one of DEBUG, INFO, WARN, ERROR, FATAL and OFF, FATAL is never disabled.
+	// The overrides take precedence over Logger.SetLevel and the admin handler, which
+	// returns them as names.
+	Names map[string]string
 	// Filter tell log handler which field are sensitive message, use * instead.
 	Filter []string
+	// Redact rules of sensitive data, see RedactConfig.
+	Redact *RedactConfig
 }
 
 // errProm prometheus error counter.
@@ -56,18 +107,21 @@ type Render interface {
 	RenderString(map[string]interface{}) string
 }
 
-var (
-	h Handler
-	c *Config
-)
+// _std holds the default *Logger used by the package level functions,
+// it's replaced atomically by Init and Close while other goroutines are logging.
+var _std atomic.Value
+
+// std returns the default Logger.
+func std() *Logger {
+	return _std.Load().(*Logger)
+}
 
 func init() {
 	host, _ := os.Hostname()
-	c = &Config{
+	_std.Store(newLogger(&Config{
 		Family: env.AppID,
 		Host:   host,
-	}
-	h = newHandlers([]string{}, NewStdout())
+	}, newHandlers(nil, NewStdout())))
 
 	SetFormat("%L %D %T  %s %F %M")
 	addFlag(flag.CommandLine)
@@ -75,11 +129,12 @@ func init() {
 
 var (
 	_v        int
+	_debug    bool
 	_stdout   bool
 	_dir      string
 	_agentDSN string
 	//_filter   logFilter
-	//_module   = verboseModule{}
+	_module  = verboseModule{}
 	_noagent bool
 )
 
@@ -88,184 +143,200 @@ func addFlag(fs *flag.FlagSet) {
 	if lv, err := strconv.ParseInt(os.Getenv("LOG_V"), 10, 64); err == nil {
 		_v = int(lv)
 	}
+	_debug, _ = strconv.ParseBool(os.Getenv("LOG_DEBUG"))
 	_stdout, _ = strconv.ParseBool(os.Getenv("LOG_STDOUT"))
 	_dir = os.Getenv("LOG_DIR")
-	/*if _agentDSN = os.Getenv("LOG_AGENT"); _agentDSN == "" {
+	if _agentDSN = os.Getenv("LOG_AGENT"); _agentDSN == "" {
 		_agentDSN = _defaultAgentConfig
-	}*/
-	/*if tm := os.Getenv("LOG_MODULE"); len(tm) > 0 {
+	}
+	if tm := os.Getenv("LOG_MODULE"); len(tm) > 0 {
 		_module.Set(tm)
-	}*/
+	}
 
 	_noagent, _ = strconv.ParseBool(os.Getenv("LOG_NO_AGENT"))
 	// get val from flag
 	fs.IntVar(&_v, "log.v", _v, "log verbose level, or use LOG_V env variable.")
+	fs.BoolVar(&_debug, "log.debug", _debug, "log enable debug level or not, or use LOG_DEBUG env variable.")
 	fs.BoolVar(&_stdout, "log.stdout", _stdout, "log enable stdout or not, or use LOG_STDOUT env variable.")
 	fs.StringVar(&_dir, "log.dir", _dir, "log file `path, or use LOG_DIR env variable.")
 	fs.StringVar(&_agentDSN, "log.agent", _agentDSN, "log agent dsn, or use LOG_AGENT env variable.")
-	//fs.Var(&_module, "log.module", "log verbose for specified module, or use LOG_MODULE env variable, format: file=1,file2=2.")
+	fs.Var(&_module, "log.module", "log verbose for specified module, or use LOG_MODULE env variable, format: file=1,file2=2.")
 	fs.BoolVar(&_noagent, "log.noagent", _noagent, "force disable log agent print log to stderr,  or use LOG_NO_AGENT")
 }
 
-// Init create logger with context.
+// Init create the default logger used by the package level functions,
+// it panics if the handlers can't be created, use New to handle the error.
 func Init(conf *Config) {
-	var isNil bool
-	if conf == nil {
-		isNil = true
-		conf = &Config{
-			Stdout: _stdout,
-			Dir:    _dir,
-			V:      int32(_v),
-			//Module: _module,
-			//Filter: _filter,
-		}
-	}
-	if len(env.AppID) != 0 {
-		conf.Family = env.AppID // for caster
-	}
-	conf.Host = env.Hostname
-	if len(conf.Host) == 0 {
-		host, _ := os.Hostname()
-		conf.Host = host
-	}
-	var hs []Handler
-	// when env is dev
-	if conf.Stdout || (isNil && (env.DeployEnv == "" || env.DeployEnv == env.DeployEnvDev)) || _noagent {
-		hs = append(hs, NewStdout())
-	}
-	if conf.Dir != "" {
-		hs = append(hs, NewFile(conf.Dir, conf.FileBufferSize, conf.RotateSize, conf.MaxLogFile))
+	l, err := New(conf)
+	if err != nil {
+		panic(err)
 	}
-	// when env is not dev
-	//if !_noagent && (conf.Agent != nil || (isNil && env.DeployEnv != "" && env.DeployEnv != env.DeployEnvDev)) {
-	//	hs = append(hs, NewAgent(conf.Agent))
-	//}
-	h = newHandlers(conf.Filter, hs...)
-	c = conf
+	_std.Store(l)
+}
+
+// Default returns the default logger used by the package level functions.
+func Default() *Logger {
+	return std()
+}
+
+// SetDefault replaces the default logger used by the package level functions,
+// e.g. with a logger of the handlers of a test, the replaced one isn't closed.
+func SetDefault(l *Logger) {
+	_std.Store(l)
+}
+
+// Debug logs a message at the debug log level, it does nothing unless Config.Debug is set.
+func Debug(args ...interface{}) {
+	std().print(_debugLevel, args)
 }
 
 // Info logs a message at the info log level.
 func Info(args ...interface{}) {
-	if ctx, ok := args[0].(context.Context); ok {
-		h.Log(ctx, _infoLevel, KVString(_log, fmt.Sprint(args[1:]...)))
-		return
-	}
-	h.Log(context.Background(), _infoLevel, KVString(_log, fmt.Sprint(args...)))
-
+	std().print(_infoLevel, args)
 }
 
 // Warn logs a message at the warning log level.
 func Warn(args ...interface{}) {
-	if ctx, ok := args[0].(context.Context); ok {
-		h.Log(ctx, _warnLevel, KVString(_log, fmt.Sprint(args[1:]...)))
-		return
-	}
-	h.Log(context.Background(), _warnLevel, KVString(_log, fmt.Sprint(args...)))
+	std().print(_warnLevel, args)
 }
 
 // Error logs a message at the error log level.
 func Error(args ...interface{}) {
-	if ctx, ok := args[0].(context.Context); ok {
-		h.Log(ctx, _errorLevel, KVString(_log, fmt.Sprint(args[1:]...)))
-		return
-	}
-	h.Log(context.Background(), _errorLevel, KVString(_log, fmt.Sprint(args...)))
+	std().print(_errorLevel, args)
+}
+
+// Fatal logs a message at the fatal log level with the stack traces of all goroutines,
+// then flushes and closes all handlers and calls os.Exit(1).
+func Fatal(args ...interface{}) {
+	std().print(_fatalLevel, args)
+}
+
+// Debugf logs a message at the debug log level, it does nothing unless Config.Debug is set.
+func Debugf(args ...interface{}) {
+	std().printf(_debugLevel, args)
 }
 
 // Infof logs a message at the info log level.
 func Infof(args ...interface{}) {
-	if ctx, ok := args[0].(context.Context); ok {
-		h.Log(ctx, _infoLevel, KVString(_log, fmt.Sprintf(args[1].(string), args[2:]...)))
-		return
-	}
-	h.Log(context.Background(), _infoLevel, KVString(_log, fmt.Sprintf(args[0].(string), args[1:]...)))
+	std().printf(_infoLevel, args)
 }
 
 // Errorf logs a message at the error log level.
 func Errorf(args ...interface{}) {
-	if ctx, ok := args[0].(context.Context); ok {
-		h.Log(ctx, _errorLevel, KVString(_log, fmt.Sprintf(args[1].(string), args[2:]...)))
-		return
-	}
-	h.Log(context.Background(), _errorLevel, KVString(_log, fmt.Sprintf(args[0].(string), args[1:]...)))
+	std().printf(_errorLevel, args)
 }
 
 // Warnf logs a message at the warning log level.
 func Warnf(args ...interface{}) {
-	if ctx, ok := args[0].(context.Context); ok {
-		h.Log(ctx, _warnLevel, KVString(_log, fmt.Sprintf(args[1].(string), args[2:]...)))
-		return
-	}
-	h.Log(context.Background(), _warnLevel, KVString(_log, fmt.Sprintf(args[0].(string), args[1:]...)))
+	std().printf(_warnLevel, args)
+}
+
+// Fatalf logs a message at the fatal log level with the stack traces of all goroutines,
+// then flushes and closes all handlers and calls os.Exit(1).
+func Fatalf(args ...interface{}) {
+	std().printf(_fatalLevel, args)
+}
+
+// Debugv logs a message at the debug log level, it does nothing unless Config.Debug is set.
+func Debugv(ctx context.Context, args ...D) {
+	std().printv(ctx, _debugLevel, args)
 }
 
 // Infov logs a message at the info log level.
 func Infov(ctx context.Context, args ...D) {
-	h.Log(ctx, _infoLevel, args...)
+	std().printv(ctx, _infoLevel, args)
 }
 
 // Warnv logs a message at the warning log level.
 func Warnv(ctx context.Context, args ...D) {
-	h.Log(ctx, _warnLevel, args...)
+	std().printv(ctx, _warnLevel, args)
 }
 
 // Errorv logs a message at the error log level.
 func Errorv(ctx context.Context, args ...D) {
-	h.Log(ctx, _errorLevel, args...)
+	std().printv(ctx, _errorLevel, args)
 }
 
-func logw(args []interface{}) []D {
-	if len(args)%2 != 0 {
-		Warn("log: the variadic must be plural, the last one will ignored")
-	}
-	ds := make([]D, 0, len(args)/2)
-	for i := 0; i < len(args)-1; i = i + 2 {
-		if key, ok := args[i].(string); ok {
-			ds = append(ds, KV(key, args[i+1]))
-		} else {
-			Warn("log: key must be string, get %T, ignored", args[i])
-		}
-	}
-	return ds
+// Fatalv logs a message at the fatal log level with the stack traces of all goroutines,
+// then flushes and closes all handlers and calls os.Exit(1).
+func Fatalv(ctx context.Context, args ...D) {
+	std().printv(ctx, _fatalLevel, args)
+}
+
+// Debugw logs a message with some additional context, it does nothing unless Config.Debug is set.
+func Debugw(ctx context.Context, args ...interface{}) {
+	std().printw(ctx, _debugLevel, args)
 }
 
 // Infow logs a message with some additional context. The variadic key-value pairs are treated as they are in With.
 func Infow(ctx context.Context, args ...interface{}) {
-	h.Log(ctx, _infoLevel, logw(args)...)
+	std().printw(ctx, _infoLevel, args)
 }
 
 // Warnw logs a message with some additional context. The variadic key-value pairs are treated as they are in With.
 func Warnw(ctx context.Context, args ...interface{}) {
-	h.Log(ctx, _warnLevel, logw(args)...)
+	std().printw(ctx, _warnLevel, args)
 }
 
 // Errorw logs a message with some additional context. The variadic key-value pairs are treated as they are in With.
 func Errorw(ctx context.Context, args ...interface{}) {
-	h.Log(ctx, _errorLevel, logw(args)...)
+	std().printw(ctx, _errorLevel, args)
+}
+
+// Fatalw logs a message with some additional context and the stack traces of all goroutines,
+// then flushes and closes all handlers and calls os.Exit(1).
+func Fatalw(ctx context.Context, args ...interface{}) {
+	std().printw(ctx, _fatalLevel, args)
 }
 
+// Named returns a named child of the default logger, see Logger.Named. It follows the
+// default logger replaced by Init, including Config.Names and the levels changed, so
+// it can be created at package level, e.g. var logger = log.Named("payment.dao").
+func Named(name string) *Logger {
+	return _stdChild.Named(name)
+}
+
+// With returns a child of the default logger which attaches fields to every entry,
+// it follows the default logger replaced by Init, so it can be created at package level.
+func With(fields ...D) *Logger {
+	return _stdChild.With(fields...)
+}
+
+// _stdChild stands for the default logger, the children made from it are resolved
+// against the current default logger on every entry.
+var _stdChild = &Logger{std: true, resolved: &atomic.Value{}}
+
 // SetFormat only effective on stdout and file handler
 // %T time format at "15:04:05.999" on stdout handler, "15:04:05 MST" on file handler
 // %t time format at "15:04:05" on stdout handler, "15:04" on file on file handler
 // %D data format at "2006/01/02"
 // %d data format at "01/02"
-// %L log level e.g. INFO WARN ERROR
+// %L log level e.g. DEBUG INFO WARN ERROR FATAL
 // %M log message and additional fields: key=value this is log message
 // %F function name  e.g. main
+// %n logger name e.g. payment.dao
 // NOTE below pattern not support on file handler
 // %i instance id
 // %e deploy env e.g. dev uat fat prod
 // %z zone
 // %S full file name and line number: /a/b/c/d.go:23
 // %s final file name element and line number: d.go:23
+// "json" outputs JSON lines instead, the keys are in a stable order:
+// time, level, app_id, instance_id, traceid, source, log, then the other fields.
 func SetFormat(format string) {
-	h.SetFormat(format)
+	std().SetFormat(format)
 }
 
 // Close close resource.
 func Close() (err error) {
-	err = h.Close()
-	h = _defaultStdout
+	l := std()
+	err = l.Close()
+	closed := *l
+	// the redactor is valid since l was created with the same config.
+	r, _ := newRedactor(l.c.Filter, l.c.Redact)
+	closed.h = newHandlers(r, _defaultStdout)
+	closed.parent, closed.fields = nil, nil
+	_std.Store(&closed)
 	return
 }
 
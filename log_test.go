@@ -0,0 +1,548 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hxchjm/log/core"
+	"github.com/stretchr/testify/assert"
+)
+
+type testEntry struct {
+	lv     Level
+	fields map[string]interface{}
+}
+
+// testHandler records every entry it received.
+type testHandler struct {
+	mu      sync.Mutex
+	entries []testEntry
+	closed  bool
+}
+
+func (th *testHandler) Log(ctx context.Context, lv Level, args ...D) {
+	th.mu.Lock()
+	th.entries = append(th.entries, testEntry{lv: lv, fields: toMap(args...)})
+	th.mu.Unlock()
+}
+
+func (th *testHandler) SetFormat(string) {}
+
+func (th *testHandler) Close() error {
+	th.closed = true
+	return nil
+}
+
+func withTestHandler(t *testing.T, conf *Config) *testHandler {
+	old := std()
+	th := &testHandler{}
+	_std.Store(newLogger(conf, newHandlers(nil, th)))
+	t.Cleanup(func() { _std.Store(old) })
+	return th
+}
+
+func TestDebug(t *testing.T) {
+	th := withTestHandler(t, &Config{})
+	Debug("hidden")
+	Debugf("hidden %d", 1)
+	Debugv(context.Background(), KVString(_log, "hidden"))
+	Debugw(context.Background(), _log, "hidden")
+	assert.Len(t, th.entries, 0)
+
+	std().SetLevel(DebugLevel)
+	Debug("shown")
+	Debugf("shown %d", 1)
+	Debugv(context.Background(), KVString(_log, "shown"))
+	Debugw(context.Background(), _log, "shown")
+	if assert.Len(t, th.entries, 4) {
+		for _, e := range th.entries {
+			assert.Equal(t, _debugLevel, e.lv)
+			assert.Equal(t, "DEBUG", e.fields[_level])
+			assert.True(t, strings.HasPrefix(e.fields[_log].(string), "shown"))
+		}
+	}
+}
+
+func TestFatal(t *testing.T) {
+	th := withTestHandler(t, &Config{})
+	var code int
+	oldExit := _exit
+	_exit = func(c int) { code = c }
+	defer func() { _exit = oldExit }()
+
+	Fatalf("boom %d", 1)
+	assert.Equal(t, 1, code)
+	assert.True(t, th.closed)
+	if assert.Len(t, th.entries, 1) {
+		e := th.entries[0]
+		assert.Equal(t, _fatalLevel, e.lv)
+		assert.Equal(t, "boom 1", e.fields[_log])
+		assert.Contains(t, e.fields[_stack], "goroutine")
+	}
+}
+
+func TestV(t *testing.T) {
+	th := withTestHandler(t, &Config{V: 1})
+	V(2).Info("hidden")
+	V(2).Infof("hidden %d", 2)
+	V(2).Infov(context.Background(), KVString(_log, "hidden"))
+	V(2).Infow(context.Background(), _log, "hidden")
+	assert.Len(t, th.entries, 0)
+
+	V(0).Info("shown")
+	V(1).Infof("shown %d", 1)
+	V(1).Infov(context.Background(), KVString(_log, "shown"))
+	V(1).Infow(context.Background(), _log, "shown")
+	assert.Len(t, th.entries, 4)
+	assert.True(t, bool(V(1)))
+	assert.False(t, bool(V(2)))
+}
+
+func TestVModule(t *testing.T) {
+	conf := &Config{V: 0, Module: map[string]int32{"log_t*": 2, "dao": 5}}
+	withTestHandler(t, conf)
+
+	for i := 0; i < 2; i++ {
+		assert.True(t, bool(V(2)))
+		assert.False(t, bool(V(3)))
+	}
+	assert.Len(t, std().levels.vmodule.Load().(*moduleCache).levels.Load().(map[uintptr]int32), 2)
+
+	m := verboseModule{}
+	assert.NoError(t, m.Set("service=1, dao*=2"))
+	assert.Equal(t, verboseModule{"service": 1, "dao*": 2}, m)
+	assert.Error(t, m.Set("service"))
+}
+
+func TestLoggerSource(t *testing.T) {
+	th := withTestHandler(t, &Config{Family: "app", Host: "host"})
+	l := newLogger(&Config{Family: "lib"}, newHandlers(nil, th))
+
+	Info("std")
+	l.Info("instance")
+	l.Infov(context.Background(), KVString(_log, "instance"))
+	V(0).Infow(context.Background(), _log, "verbose")
+	if assert.Len(t, th.entries, 4) {
+		for _, e := range th.entries {
+			assert.Contains(t, e.fields[_source], "log_test.go:")
+			assert.Equal(t, "TestLoggerSource", e.fields[_funcName])
+		}
+		assert.Equal(t, "app", th.entries[0].fields[_appID])
+		assert.Equal(t, "lib", th.entries[1].fields[_appID])
+	}
+}
+
+func TestNewBadDir(t *testing.T) {
+	f, err := ioutil.TempFile("", "log")
+	if !assert.NoError(t, err) {
+		return
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	l, err := New(&Config{Dir: f.Name()})
+	assert.Error(t, err)
+	assert.Nil(t, l)
+}
+
+func TestNewBadLevel(t *testing.T) {
+	for _, conf := range []*Config{
+		{StdoutLevel: "WARNING"},
+		{FileLevel: "verbose"},
+		{Names: map[string]string{"payment": "LOUD"}},
+	} {
+		l, err := New(conf)
+		assert.Error(t, err)
+		assert.Nil(t, l)
+	}
+}
+
+func TestWith(t *testing.T) {
+	th := &testHandler{}
+	l := newLogger(&Config{}, newHandlers(&redactor{exact: map[string]struct{}{"token": {}}}, th))
+	child := l.With(KVInt("uid", 1), KVString("token", "secret"))
+	grandchild := child.With(KVString("order_id", "o1"), KVInt("uid", 2))
+
+	l.Info("root")
+	child.Info("child")
+	grandchild.Infov(context.Background(), KVString(_log, "grandchild"), KVInt("uid", 3))
+	if assert.Len(t, th.entries, 3) {
+		assert.NotContains(t, th.entries[0].fields, "uid")
+		assert.Equal(t, int64(1), th.entries[1].fields["uid"])
+		assert.Equal(t, "***", th.entries[1].fields["token"])
+		assert.Equal(t, "o1", th.entries[2].fields["order_id"])
+		assert.Equal(t, int64(3), th.entries[2].fields["uid"])
+	}
+
+	sh := NewStdout()
+	sc := sh.With(KVInt("uid", 1)).(*StdoutHandler).With(KVString("order_id", "o1"), KVInt("uid", 2))
+	assert.Equal(t, []D{KVInt("uid", 1), KVString("order_id", "o1"), KVInt("uid", 2)}, sc.(*StdoutHandler).fields)
+	assert.Nil(t, sh.fields)
+}
+
+// _pkgWith is created before the default logger is replaced, like a package level logger.
+var _pkgWith = With(KVString("svc", "x"))
+
+func TestWithFollowsDefault(t *testing.T) {
+	th := withTestHandler(t, &Config{})
+	_pkgWith.Error("after replace")
+	_pkgWith.With(KVInt("uid", 1)).Info("grandchild")
+	if assert.Len(t, th.entries, 2) {
+		assert.Equal(t, "x", th.entries[0].fields["svc"])
+		assert.Equal(t, "x", th.entries[1].fields["svc"])
+		assert.Equal(t, int64(1), th.entries[1].fields["uid"])
+	}
+
+	// the children follow SetFormat of the parent.
+	buf := &bytes.Buffer{}
+	l := newLogger(&Config{}, newHandlers(nil, NewWriterHandler(buf, "%L %M")))
+	child := l.With(KVInt("uid", 1))
+	l.SetFormat(FormatJSON)
+	child.Info("json")
+	var e map[string]interface{}
+	if assert.NoError(t, json.Unmarshal(buf.Bytes(), &e), buf.String()) {
+		assert.Equal(t, float64(1), e["uid"])
+	}
+}
+
+func TestNamed(t *testing.T) {
+	th := &testHandler{}
+	l := newLogger(&Config{Names: map[string]string{"payment": "warn", "payment.dao": "OFF", "payment.dao.order": "debug"}}, newHandlers(nil, th))
+	pay := l.Named("payment")
+	dao := pay.Named("dao")
+	order := dao.Named("order")
+	other := l.Named("other").Named("")
+
+	pay.Info("hidden")
+	pay.Warn("shown")
+	dao.Error("hidden")
+	order.Debug("shown")
+	other.Info("shown")
+	other.Debug("hidden")
+	if assert.Len(t, th.entries, 3) {
+		assert.Equal(t, "payment", th.entries[0].fields[_logger])
+		assert.Equal(t, "payment.dao.order", th.entries[1].fields[_logger])
+		assert.Equal(t, "other", th.entries[2].fields[_logger])
+	}
+
+	buf := &bytes.Buffer{}
+	newPatternRender("[%n] %M").Render(buf, th.entries[1].fields)
+	assert.Equal(t, "[payment.dao.order] shown", buf.String())
+}
+
+// _pkgNamed is created before the default logger is replaced, like a package level logger.
+var _pkgNamed = Named("payment").Named("dao")
+
+func TestNamedFollowsDefault(t *testing.T) {
+	th := withTestHandler(t, &Config{Names: map[string]string{"payment": "ERROR"}})
+	_pkgNamed.Warn("hidden")
+	_pkgNamed.Error("shown")
+	if assert.Len(t, th.entries, 1) {
+		assert.Equal(t, "payment.dao", th.entries[0].fields[_logger])
+	}
+
+	// the levels changed on the default logger apply to the children.
+	th = withTestHandler(t, &Config{})
+	Default().SetLevel(WarnLevel)
+	_pkgNamed.Info("hidden")
+	assert.Equal(t, WarnLevel, _pkgNamed.Level())
+	assert.Empty(t, th.entries)
+}
+
+func TestHandlerLevel(t *testing.T) {
+	all, warn := &testHandler{}, &testHandler{}
+	l := newLogger(&Config{}, newHandlers(nil, all, MinLevel(warn, WarnLevel)))
+	l.Info("info")
+	l.With(KVInt("uid", 1)).Warn("warn")
+	l.Error("error")
+	assert.Len(t, all.entries, 3)
+	if assert.Len(t, warn.entries, 2) {
+		assert.Equal(t, int64(1), warn.entries[0].fields["uid"])
+	}
+
+	only := &testHandler{}
+	l = newLogger(&Config{}, newHandlers(nil, MinLevel(only, ErrorLevel)))
+	assert.False(t, l.enabled(WarnLevel))
+	assert.True(t, l.enabled(ErrorLevel))
+
+	sh := NewStdout()
+	sh.SetLevel(WarnLevel)
+	assert.False(t, sh.Enabled(InfoLevel))
+	assert.True(t, sh.With(KVInt("uid", 1)).(*StdoutHandler).Enabled(WarnLevel))
+}
+
+func TestContextExtractor(t *testing.T) {
+	th := withTestHandler(t, &Config{})
+	old := _extractors.Load()
+	defer _extractors.Store(old)
+	RegisterExtractor(func(ctx context.Context) []D {
+		if caller, ok := ctx.Value(_caller).(string); ok {
+			return []D{KVString(_caller, caller)}
+		}
+		return nil
+	})
+
+	ctx := NewTraceContext(context.Background(), "t1")
+	ctx = NewContext(ctx, KVInt("uid", 1))
+	ctx = NewContext(ctx, KVString("order_id", "o1"))
+	ctx = context.WithValue(ctx, _caller, "gateway")
+	Info(ctx, "typed")
+	Infov(context.WithValue(context.Background(), _legacyTraceKey, "t2"), KVString(_log, "legacy"))
+	if assert.Len(t, th.entries, 2) {
+		assert.Equal(t, "t1", th.entries[0].fields[_tid])
+		assert.Equal(t, int64(1), th.entries[0].fields["uid"])
+		assert.Equal(t, "o1", th.entries[0].fields["order_id"])
+		assert.Equal(t, "gateway", th.entries[0].fields[_caller])
+		assert.Equal(t, "t2", th.entries[1].fields[_tid])
+		assert.NotContains(t, th.entries[1].fields, "uid")
+	}
+}
+
+func TestContextFieldsOverridden(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := newLogger(&Config{}, newHandlers(nil, NewWriterHandler(buf, "%M")))
+	ctx := NewContext(context.Background(), KVInt("uid", 1), KVString("order_id", "o1"))
+	l.Infov(ctx, KVString(_log, "explicit"), KVInt("uid", 2))
+	assert.Contains(t, buf.String(), "uid=2")
+	assert.NotContains(t, buf.String(), "uid=1")
+	assert.Contains(t, buf.String(), "order_id=o1")
+}
+
+func TestLogKeepsArgs(t *testing.T) {
+	th := &testHandler{}
+	l := newLogger(&Config{Family: "app"}, newHandlers(nil, th))
+	args := make([]D, 1, 8)
+	args[0] = KVString(_log, "a")
+	spare := args[:8]
+	spare[1] = KVString("kept", "x")
+	l.Infov(NewContext(context.Background(), KVInt("uid", 1)), args...)
+	assert.Equal(t, KVString("kept", "x"), spare[1])
+	assert.Len(t, args, 1)
+	if assert.Len(t, th.entries, 1) {
+		assert.Equal(t, int64(1), th.entries[0].fields["uid"])
+	}
+}
+
+func TestJSONFormat(t *testing.T) {
+	enc := newEntryEncoder(FormatJSON, []D{KVString("order_id", "o1"), KVString(_appID, "ignored")})
+	buf := core.GetPool()
+	defer buf.Free()
+	err := enc.Encode(buf, []D{
+		KVString(_log, "hello \"json\""),
+		KVInt("uid", 1),
+		KVString(_source, "log_test.go:1"),
+		KVString(_level, "INFO"),
+		KVInt(_levelValue, 1),
+		KVString(_funcName, "TestJSONFormat"),
+		KVString(_appID, "app"),
+		KV(_time, 1.5),
+		KV("ok", true),
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, `{"time":1.5,"level":"INFO","app_id":"app","source":"log_test.go:1","log":"hello \"json\"","order_id":"o1","uid":1,"ok":true}`+"\n", buf.String())
+}
+
+func TestJSONUniqueKeys(t *testing.T) {
+	enc := newEntryEncoder(FormatJSON, nil).With([]D{KVInt("uid", 1), KVString("order_id", "o1")}).With([]D{KVString("order_id", "o2")})
+	buf := core.GetPool()
+	defer buf.Free()
+	assert.NoError(t, enc.Encode(buf, []D{KVString(_log, "a"), KVInt("uid", 2)}))
+	assert.Equal(t, `{"log":"a","order_id":"o2","uid":2}`+"\n", buf.String())
+
+	buf.Reset()
+	assert.NoError(t, enc.Encode(buf, []D{KVString(_log, "b")}))
+	assert.Equal(t, `{"log":"b","uid":1,"order_id":"o2"}`+"\n", buf.String())
+
+	// the first one of the entry fields of the same key is kept.
+	buf.Reset()
+	assert.NoError(t, enc.Encode(buf, []D{KVString(_log, "c"), KVInt("uid", 2), KVInt("uid", 3), KVString(_log, "d")}))
+	assert.Equal(t, `{"log":"c","order_id":"o2","uid":2}`+"\n", buf.String())
+}
+
+func TestWriterHandler(t *testing.T) {
+	buf := &bytes.Buffer{}
+	wh := NewWriterHandler(buf, FormatJSON)
+	l := newLogger(&Config{}, newHandlers(nil, wh))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			cl := l.With(KVInt("goroutine", i))
+			for j := 0; j < 100; j++ {
+				cl.Info(strings.Repeat("x", 512))
+			}
+		}(i)
+	}
+	wg.Wait()
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	assert.Len(t, lines, 800)
+	for _, line := range lines {
+		var e map[string]interface{}
+		if !assert.NoError(t, json.Unmarshal([]byte(line), &e), line) {
+			return
+		}
+	}
+
+	buf.Reset()
+	wh.SetFormat("[%L] %M")
+	l.Warn("hello")
+	assert.Equal(t, "[WARN] hello\n", buf.String())
+
+	buf.Reset()
+	l, err := New(&Config{Stdout: true, StdoutWriter: buf, StdoutFormat: "[%L] %M"})
+	if assert.NoError(t, err) {
+		l.Info("to writer")
+		assert.Equal(t, "[INFO] to writer\n", buf.String())
+	}
+
+	// the console picked without StdoutFormat follows SetFormat.
+	buf.Reset()
+	l, err = New(&Config{Stdout: true, StdoutWriter: buf})
+	if assert.NoError(t, err) {
+		l.SetFormat("[%L] %M")
+		l.Info("formatted")
+		assert.Equal(t, "[INFO] formatted\n", buf.String())
+	}
+}
+
+func TestSetFormatConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	fh, err := newFileRoutes(dir, fileRoutes(&Config{}))
+	if !assert.NoError(t, err) {
+		return
+	}
+	hs := []Handler{NewWriterHandler(ioutil.Discard, "%L %M"), fh}
+	l := newLogger(&Config{}, newHandlers(nil, hs...))
+	defer l.Close()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				l.Info("concurrent")
+			}
+		}()
+	}
+	for _, format := range []string{FormatJSON, "%L %M", FormatJSON, "%T %M"} {
+		for _, h := range hs {
+			h.SetFormat(format)
+		}
+	}
+	wg.Wait()
+}
+
+func TestFileRoutes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	l, err := New(&Config{Dir: dir, Debug: true, FileRoutes: []FileRoute{
+		{Name: "audit.log", Field: "category", Value: "audit", Stop: true, Format: "%M"},
+		{Name: "slow.log", Field: "slow_query", Format: "%M"},
+		{Name: "error.log", MinLevel: "ERROR", Format: "%M"},
+		{Name: "app.log", Fallback: true, Format: "%M"},
+	}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	l.Debug("debug")
+	l.Infov(context.Background(), KVString(_log, "login"), KVString("category", "audit"))
+	l.Errorv(context.Background(), KVString(_log, "denied"), KVString("category", "audit"))
+	l.Infov(context.Background(), KVString(_log, "other"), KVString("category", "biz"))
+	l.With(KVInt("slow_query", 2)).Error("slow")
+	assert.NoError(t, l.Close())
+
+	read := func(name string) string {
+		b, _ := ioutil.ReadFile(filepath.Join(dir, name))
+		return string(b)
+	}
+	assert.Equal(t, "category=audit login\ncategory=audit denied\n", read("audit.log"))
+	assert.Equal(t, "slow_query=2 slow\n", read("slow.log"))
+	assert.Equal(t, "slow_query=2 slow\n", read("error.log"))
+	assert.Equal(t, "debug\ncategory=biz other\n", read("app.log"))
+
+	_, err = New(&Config{Dir: dir, FileRoutes: []FileRoute{{Name: "a.log", MinLevel: "TRACE"}}})
+	assert.Error(t, err)
+}
+
+// countEncoder counts the entries encoded.
+type countEncoder struct {
+	entryEncoder
+	n *int
+}
+
+func (e countEncoder) Encode(buf *core.Buffer, args []D) error {
+	*e.n++
+	return e.entryEncoder.Encode(buf, args)
+}
+
+func TestFileCascade(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	fh, err := newFileRoutes(dir, fileRoutes(&Config{FileCascade: true, FileFormat: "%L %M"}))
+	if !assert.NoError(t, err) {
+		return
+	}
+	var n int
+	fh.routing().encs[0] = countEncoder{entryEncoder: fh.routing().encs[0], n: &n}
+	l := newLogger(&Config{Debug: true}, newHandlers(nil, fh))
+	l.Debug("d")
+	l.Info("i")
+	l.Warn("w")
+	l.Error("e")
+	assert.NoError(t, l.Close())
+	assert.Equal(t, 4, n)
+
+	read := func(name string) string {
+		b, _ := ioutil.ReadFile(filepath.Join(dir, name))
+		return string(b)
+	}
+	assert.Equal(t, "DEBUG d\nINFO i\nWARN w\nERROR e\n", read("info.log"))
+	assert.Equal(t, "WARN w\nERROR e\n", read("warning.log"))
+	assert.Equal(t, "ERROR e\n", read("error.log"))
+}
+
+func TestConsoleHandler(t *testing.T) {
+	var out bytes.Buffer
+	ch := NewConsole(&out)
+	assert.False(t, ch.encoder().(*consoleEncoder).color)
+	l := newLogger(&Config{Family: "main.app"}, newHandlers(nil, ch))
+	l.With(KVString("order_id", "o 1")).Infov(context.Background(), KVString(_log, "hi"), KVInt("uid", 1), KVString("sql", "select 1\nfrom t"))
+	line := out.String()
+	assert.Regexp(t, `^\d\d:\d\d:\d\d\.\d{3} INFO  log_test\.go:\d+ hi order_id="o 1" uid=1 app_id=main\.app\n    sql=\n        select 1\n        from t\n$`, line)
+
+	out.Reset()
+	ch.encoder().(*consoleEncoder).color = true
+	l.Error("e")
+	assert.Contains(t, out.String(), _colorRed+"ERROR"+_colorReset+" ")
+	assert.Contains(t, out.String(), _colorDim+"app_id=main.app"+_colorReset)
+
+	// the format of the caller replaces the console output.
+	out.Reset()
+	ch.SetFormat("[%L] %M")
+	l.Info("pattern")
+	assert.True(t, strings.HasPrefix(out.String(), "[INFO] pattern"), out.String())
+
+	assert.Equal(t, "log/log.go:1", relSource(filepath.Join("/nonexistent", "log", "log.go")+":1"))
+}
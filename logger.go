@@ -0,0 +1,668 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hxchjm/log/env"
+)
+
+// _callerSkip is the number of frames between Logger.log and the user's call site:
+// Logger.log <- Logger.print* <- Info/Logger.Info... <- caller.
+const _callerSkip = 3
+
+// Logger is a logger with its own handlers and config,
+// A Logger is safe for use by multiple goroutines simultaneously.
+type Logger struct {
+	h      Handler
+	c      *Config
+	levels *levelState
+
+	name string
+	// level overrides the minimum level by Config.Names, _noLevel if not set.
+	level Level
+
+	// parent and fields are set by With, bound caches the handler binds fields to the one
+	// of parent, it's rebuilt after SetFormat so the children follow the format of the
+	// handlers. nil parent means h.
+	parent *Logger
+	fields []D
+	bound  *atomic.Value // *boundHandler
+
+	// std reports whether l is a child of the default logger made by the package level
+	// Named or With, it's resolved against the default logger on every entry so it
+	// follows Init. parent is the std logger it's made from, nil means the default
+	// logger, fields and name are applied to the resolved parent, which is cached in
+	// resolved.
+	std      bool
+	resolved *atomic.Value // *resolvedLogger
+}
+
+// boundHandler is the handler of a child logger and the generation of the format it's
+// bound with.
+type boundHandler struct {
+	h   Handler
+	gen uint64
+}
+
+// resolvedLogger is a std logger resolved against base.
+type resolvedLogger struct {
+	base *Logger
+	l    *Logger
+}
+
+// New create a logger, unlike Init the returned Logger doesn't replace the one used by
+// the package level functions. nil conf means using the flags and env variables, the
+// entries go to the agent if env.DeployEnv, i.e. the DEPLOY_ENV env variable, isn't dev.
+func New(conf *Config) (*Logger, error) {
+	var isNil bool
+	if conf == nil {
+		isNil = true
+		conf = &Config{
+			Debug:  _debug,
+			Stdout: _stdout,
+			Dir:    _dir,
+			V:      int32(_v),
+			Module: _module,
+			//Filter: _filter,
+		}
+	}
+	if len(env.AppID) != 0 {
+		conf.Family = env.AppID // for caster
+	}
+	conf.Host = env.Hostname
+	if len(conf.Host) == 0 {
+		host, _ := os.Hostname()
+		conf.Host = host
+	}
+	if err := checkLevels(conf); err != nil {
+		return nil, err
+	}
+	r, err := newRedactor(conf.Filter, conf.Redact)
+	if err != nil {
+		return nil, err
+	}
+	var hs []Handler
+	// when env is dev
+	dev := env.DeployEnv == "" || env.DeployEnv == env.DeployEnvDev
+	if conf.Stdout || (isNil && dev) || _noagent {
+		var sh interface {
+			Handler
+			SetLevel(Level)
+		}
+		w := conf.StdoutWriter
+		if w == nil {
+			w = os.Stderr
+		}
+		if conf.StdoutFormat == "" && dev {
+			// human-friendly for local development.
+			sh = NewConsole(w)
+		} else {
+			sh = NewStdoutWriter(w)
+		}
+		if lv, ok := parseLevel(conf.StdoutLevel); ok {
+			sh.SetLevel(lv)
+		}
+		if conf.StdoutFormat != "" {
+			sh.SetFormat(conf.StdoutFormat)
+		}
+		hs = append(hs, sh)
+	}
+	if conf.Dir != "" {
+		fh, err := newFileRoutes(conf.Dir, fileRoutes(conf))
+		if err != nil {
+			return nil, err
+		}
+		if lv, ok := parseLevel(conf.FileLevel); ok {
+			fh.SetLevel(lv)
+		}
+		hs = append(hs, fh)
+	}
+	// when env is not dev
+	if !_noagent && (conf.Agent != nil || (isNil && env.DeployEnv != "" && env.DeployEnv != env.DeployEnvDev)) {
+		ah, err := newAgent(conf.Agent)
+		if err != nil {
+			return nil, err
+		}
+		hs = append(hs, ah)
+	}
+	if conf.Syslog != nil {
+		sh, err := newSyslog(conf.Syslog)
+		if err != nil {
+			return nil, err
+		}
+		hs = append(hs, sh)
+	}
+	if conf.HTTP != nil {
+		hh, err := newHTTP(conf.HTTP)
+		if err != nil {
+			return nil, err
+		}
+		hs = append(hs, hh)
+	}
+	if conf.GELF != nil {
+		gh, err := newGELF(conf.GELF)
+		if err != nil {
+			return nil, err
+		}
+		hs = append(hs, gh)
+	}
+	if conf.Fluent != nil {
+		fh, err := newFluent(conf.Fluent)
+		if err != nil {
+			return nil, err
+		}
+		hs = append(hs, fh)
+	}
+	hs = append(hs, conf.Handlers...)
+	if conf.Async != nil {
+		for i, h := range hs {
+			ah, err := newAsync(h, conf.Async)
+			if err != nil {
+				newHandlers(nil, hs...).Close()
+				return nil, err
+			}
+			hs[i] = ah
+		}
+	}
+	if conf.Sampler != nil {
+		for i, h := range hs {
+			sh, err := newSampler(h, conf.Sampler)
+			if err != nil {
+				newHandlers(nil, hs...).Close()
+				return nil, err
+			}
+			hs[i] = sh
+		}
+	}
+	if conf.Dedupe != nil {
+		for i, h := range hs {
+			hs[i] = Dedupe(h, conf.Dedupe)
+		}
+	}
+	return newLogger(conf, newHandlers(r, hs...)), nil
+}
+
+// checkLevels returns an error if a level name of conf is invalid, empty means not set.
+func checkLevels(conf *Config) error {
+	for key, name := range map[string]string{"StdoutLevel": conf.StdoutLevel, "FileLevel": conf.FileLevel} {
+		if _, ok := parseLevel(name); name != "" && !ok {
+			return fmt.Errorf("log: invalid %s %q", key, name)
+		}
+	}
+	for logger, name := range conf.Names {
+		if _, ok := parseLevel(name); !ok {
+			return fmt.Errorf("log: invalid level %q of logger %q", name, logger)
+		}
+	}
+	return nil
+}
+
+// fileRoutes returns the file routes of conf with the defaults filled.
+func fileRoutes(conf *Config) []FileRoute {
+	routes := conf.FileRoutes
+	if len(routes) == 0 {
+		routes = _fileRoutes
+		if conf.FileCascade {
+			routes = _cascadeRoutes
+		}
+	}
+	filled := make([]FileRoute, len(routes))
+	for i, r := range routes {
+		if r.RotateSize == 0 {
+			r.RotateSize = conf.RotateSize
+		}
+		if r.MaxLogFile == 0 {
+			r.MaxLogFile = conf.MaxLogFile
+		}
+		if r.Format == "" {
+			r.Format = conf.FileFormat
+		}
+		filled[i] = r
+	}
+	return filled
+}
+
+func newLogger(conf *Config, h Handler) *Logger {
+	return &Logger{h: h, c: conf, levels: newLevelState(conf), level: _noLevel}
+}
+
+// levelState is the levels of a logger which can be changed while logging,
+// it's shared by the logger and its children.
+type levelState struct {
+	level   *atomicLevel
+	v       int32
+	vmodule atomic.Value // *moduleCache
+
+	// mu guards the pending revert of a change with ttl.
+	mu     sync.Mutex
+	revert *time.Timer
+	saved  *levelSnapshot
+	// gen increases every change, so a stale revert is ignored.
+	gen uint64
+
+	// formats increases every SetFormat, so the children rebuild their bound handlers.
+	formats uint64
+}
+
+// levelSnapshot is a copy of levelState.
+type levelSnapshot struct {
+	level  Level
+	v      int32
+	module map[string]int32
+}
+
+func newLevelState(conf *Config) *levelState {
+	lv := _infoLevel
+	if conf.Debug {
+		lv = _debugLevel
+	}
+	ls := &levelState{level: newAtomicLevel(lv), v: conf.V}
+	ls.vmodule.Store(newModuleCache(conf.Module))
+	return ls
+}
+
+// V returns the V level.
+func (ls *levelState) V() int32 {
+	return atomic.LoadInt32(&ls.v)
+}
+
+// Module returns the module V levels, it must not be modified.
+func (ls *levelState) Module() map[string]int32 {
+	return ls.vmodule.Load().(*moduleCache).module
+}
+
+func (ls *levelState) snapshot() *levelSnapshot {
+	return &levelSnapshot{level: ls.level.Level(), v: ls.V(), module: ls.Module()}
+}
+
+func (ls *levelState) restore(s *levelSnapshot) {
+	ls.level.SetLevel(s.level)
+	atomic.StoreInt32(&ls.v, s.v)
+	ls.vmodule.Store(newModuleCache(s.module))
+}
+
+// change applies fn to the levels, they revert to the state before the first pending
+// change after ttl, or stay if ttl is 0.
+func (ls *levelState) change(ttl time.Duration, fn func()) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.gen++
+	if ls.revert != nil {
+		ls.revert.Stop()
+		ls.revert = nil
+	}
+	if ttl <= 0 {
+		ls.saved = nil
+		fn()
+		return
+	}
+	if ls.saved == nil {
+		ls.saved = ls.snapshot()
+	}
+	fn()
+	gen := ls.gen
+	ls.revert = time.AfterFunc(ttl, func() { ls.expire(gen) })
+}
+
+// expire reverts the change of gen when its ttl expires, unless a newer change has
+// replaced it.
+func (ls *levelState) expire(gen uint64) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if gen != ls.gen || ls.revert == nil {
+		return
+	}
+	ls.restore(ls.saved)
+	ls.saved, ls.revert = nil, nil
+}
+
+// Level returns the minimum level of l, Config.Names overrides it for the named loggers.
+func (l *Logger) Level() Level {
+	l = l.current()
+	return l.levels.level.Level()
+}
+
+// SetLevel changes the minimum level of l and its children while logging, the named
+// loggers overridden by Config.Names keep their levels.
+func (l *Logger) SetLevel(lv Level) {
+	l = l.current()
+	l.levels.change(0, func() { l.levels.level.SetLevel(lv) })
+}
+
+// SetV changes the V level of l and its children while logging.
+func (l *Logger) SetV(v int32) {
+	l = l.current()
+	l.levels.change(0, func() { atomic.StoreInt32(&l.levels.v, v) })
+}
+
+// SetModule replaces the module V levels of l and its children while logging,
+// see Config.Module for detail.
+func (l *Logger) SetModule(module map[string]int32) {
+	l = l.current()
+	l.levels.change(0, func() { l.levels.vmodule.Store(newModuleCache(module)) })
+}
+
+// Debug logs a message at the debug log level, it does nothing unless Config.Debug is set.
+func (l *Logger) Debug(args ...interface{}) {
+	l.print(_debugLevel, args)
+}
+
+// Info logs a message at the info log level.
+func (l *Logger) Info(args ...interface{}) {
+	l.print(_infoLevel, args)
+}
+
+// Warn logs a message at the warning log level.
+func (l *Logger) Warn(args ...interface{}) {
+	l.print(_warnLevel, args)
+}
+
+// Error logs a message at the error log level.
+func (l *Logger) Error(args ...interface{}) {
+	l.print(_errorLevel, args)
+}
+
+// Fatal logs a message at the fatal log level with the stack traces of all goroutines,
+// then flushes and closes the handlers of l and calls os.Exit(1).
+func (l *Logger) Fatal(args ...interface{}) {
+	l.print(_fatalLevel, args)
+}
+
+// Debugf logs a message at the debug log level, it does nothing unless Config.Debug is set.
+func (l *Logger) Debugf(args ...interface{}) {
+	l.printf(_debugLevel, args)
+}
+
+// Infof logs a message at the info log level.
+func (l *Logger) Infof(args ...interface{}) {
+	l.printf(_infoLevel, args)
+}
+
+// Warnf logs a message at the warning log level.
+func (l *Logger) Warnf(args ...interface{}) {
+	l.printf(_warnLevel, args)
+}
+
+// Errorf logs a message at the error log level.
+func (l *Logger) Errorf(args ...interface{}) {
+	l.printf(_errorLevel, args)
+}
+
+// Fatalf logs a message at the fatal log level with the stack traces of all goroutines,
+// then flushes and closes the handlers of l and calls os.Exit(1).
+func (l *Logger) Fatalf(args ...interface{}) {
+	l.printf(_fatalLevel, args)
+}
+
+// Debugv logs a message at the debug log level, it does nothing unless Config.Debug is set.
+func (l *Logger) Debugv(ctx context.Context, args ...D) {
+	l.printv(ctx, _debugLevel, args)
+}
+
+// Infov logs a message at the info log level.
+func (l *Logger) Infov(ctx context.Context, args ...D) {
+	l.printv(ctx, _infoLevel, args)
+}
+
+// Warnv logs a message at the warning log level.
+func (l *Logger) Warnv(ctx context.Context, args ...D) {
+	l.printv(ctx, _warnLevel, args)
+}
+
+// Errorv logs a message at the error log level.
+func (l *Logger) Errorv(ctx context.Context, args ...D) {
+	l.printv(ctx, _errorLevel, args)
+}
+
+// Fatalv logs a message at the fatal log level with the stack traces of all goroutines,
+// then flushes and closes the handlers of l and calls os.Exit(1).
+func (l *Logger) Fatalv(ctx context.Context, args ...D) {
+	l.printv(ctx, _fatalLevel, args)
+}
+
+// Debugw logs a message with some additional context, it does nothing unless Config.Debug is set.
+func (l *Logger) Debugw(ctx context.Context, args ...interface{}) {
+	l.printw(ctx, _debugLevel, args)
+}
+
+// Infow logs a message with some additional context. The variadic key-value pairs are treated as they are in With.
+func (l *Logger) Infow(ctx context.Context, args ...interface{}) {
+	l.printw(ctx, _infoLevel, args)
+}
+
+// Warnw logs a message with some additional context. The variadic key-value pairs are treated as they are in With.
+func (l *Logger) Warnw(ctx context.Context, args ...interface{}) {
+	l.printw(ctx, _warnLevel, args)
+}
+
+// Errorw logs a message with some additional context. The variadic key-value pairs are treated as they are in With.
+func (l *Logger) Errorw(ctx context.Context, args ...interface{}) {
+	l.printw(ctx, _errorLevel, args)
+}
+
+// Fatalw logs a message with some additional context and the stack traces of all goroutines,
+// then flushes and closes the handlers of l and calls os.Exit(1).
+func (l *Logger) Fatalw(ctx context.Context, args ...interface{}) {
+	l.printw(ctx, _fatalLevel, args)
+}
+
+// With returns a child logger which attaches fields to every entry, the fields are
+// encoded once by the handlers when the child is created and again after SetFormat.
+// The child shares the handlers with l, so only the root logger need to be closed.
+func (l *Logger) With(fields ...D) *Logger {
+	if len(fields) == 0 {
+		return l
+	}
+	// fields may be reused by the caller.
+	fields = append([]D(nil), fields...)
+	if l.std {
+		return &Logger{std: true, parent: l, fields: fields, resolved: &atomic.Value{}}
+	}
+	child := *l
+	child.parent, child.fields = l, fields
+	child.bound = &atomic.Value{}
+	gen := atomic.LoadUint64(&l.levels.formats)
+	child.bound.Store(&boundHandler{h: withFields(l.handler(), fields), gen: gen})
+	return &child
+}
+
+// handler returns the handler of l, which binds the fields of l and its parents.
+func (l *Logger) handler() Handler {
+	if l.parent == nil {
+		return l.h
+	}
+	gen := atomic.LoadUint64(&l.levels.formats)
+	if b := l.bound.Load().(*boundHandler); b.gen == gen {
+		return b.h
+	}
+	b := &boundHandler{h: withFields(l.parent.handler(), l.fields), gen: gen}
+	l.bound.Store(b)
+	return b.h
+}
+
+// current returns l, or the child of the current default logger l stands for if l is
+// a std logger.
+func (l *Logger) current() *Logger {
+	if !l.std {
+		return l
+	}
+	base := std()
+	if l.parent != nil {
+		base = l.parent.current()
+	}
+	if r, _ := l.resolved.Load().(*resolvedLogger); r != nil && r.base == base {
+		return r.l
+	}
+	r := &resolvedLogger{base: base, l: base.With(l.fields...).Named(l.name)}
+	l.resolved.Store(r)
+	return r.l
+}
+
+// Named returns a child logger whose name is added to every entry as the "logger" field,
+// and rendered by the %n pattern. Nested names are joined with dots, e.g. payment.dao.
+// The minimum level of the child can be overridden by Config.Names.
+func (l *Logger) Named(name string) *Logger {
+	if name == "" {
+		return l
+	}
+	if l.std {
+		return &Logger{std: true, parent: l, name: name, resolved: &atomic.Value{}}
+	}
+	if l.name != "" {
+		name = l.name + "." + name
+	}
+	child := *l
+	child.name = name
+	child.level = nameLevel(l.c.Names, name)
+	return &child
+}
+
+// nameLevel returns the level of the longest name in names matches name or its parents.
+func nameLevel(names map[string]string, name string) Level {
+	for len(names) != 0 {
+		if s, ok := names[name]; ok {
+			if lv, ok := parseLevel(s); ok {
+				return lv
+			}
+		}
+		i := strings.LastIndexByte(name, '.')
+		if i < 0 {
+			break
+		}
+		name = name[:i]
+	}
+	return _noLevel
+}
+
+// SetFormat set render format of the handlers shared by l, its parents and children,
+// see SetFormat for detail.
+func (l *Logger) SetFormat(format string) {
+	l = l.current()
+	l.h.SetFormat(format)
+	atomic.AddUint64(&l.levels.formats, 1)
+}
+
+// Close close resource.
+func (l *Logger) Close() error {
+	return l.current().h.Close()
+}
+
+// enabled reports whether the lv is enabled before any argument is formatted,
+// fatal is always enabled because it terminates the process.
+func (l *Logger) enabled(lv Level) bool {
+	if lv == _fatalLevel {
+		return true
+	}
+	if l.level != _noLevel {
+		if lv < l.level {
+			return false
+		}
+	} else if !l.levels.level.Enabled(lv) {
+		return false
+	}
+	return handlerEnabled(l.h, lv)
+}
+
+func (l *Logger) print(lv Level, args []interface{}) {
+	l = l.current()
+	if !l.enabled(lv) {
+		return
+	}
+	if ctx, ok := args[0].(context.Context); ok {
+		l.log(ctx, lv, KVString(_log, fmt.Sprint(args[1:]...)))
+		return
+	}
+	l.log(context.Background(), lv, KVString(_log, fmt.Sprint(args...)))
+}
+
+func (l *Logger) printf(lv Level, args []interface{}) {
+	l = l.current()
+	if !l.enabled(lv) {
+		return
+	}
+	if ctx, ok := args[0].(context.Context); ok {
+		l.log(ctx, lv, KVString(_log, fmt.Sprintf(args[1].(string), args[2:]...)))
+		return
+	}
+	l.log(context.Background(), lv, KVString(_log, fmt.Sprintf(args[0].(string), args[1:]...)))
+}
+
+func (l *Logger) printv(ctx context.Context, lv Level, args []D) {
+	l = l.current()
+	if !l.enabled(lv) {
+		return
+	}
+	l.log(ctx, lv, args...)
+}
+
+func (l *Logger) printw(ctx context.Context, lv Level, args []interface{}) {
+	l = l.current()
+	if !l.enabled(lv) {
+		return
+	}
+	l.log(ctx, lv, l.logw(args)...)
+}
+
+func (l *Logger) logw(args []interface{}) []D {
+	if len(args)%2 != 0 {
+		l.Warn("log: the variadic must be plural, the last one will ignored")
+	}
+	ds := make([]D, 0, len(args)/2)
+	for i := 0; i < len(args)-1; i = i + 2 {
+		if key, ok := args[i].(string); ok {
+			ds = append(ds, KV(key, args[i+1]))
+		} else {
+			l.Warnf("log: key must be string, get %T, ignored", args[i])
+		}
+	}
+	return ds
+}
+
+// log adds the call site and common fields to the entry and hands it to the handlers,
+// it must be called _callerSkip frames below the user's call site.
+func (l *Logger) log(ctx context.Context, lv Level, args ...D) {
+	// args may be shared by the caller, the common fields are appended to a copy.
+	d := make([]D, 0, len(args)+6)
+	d = append(d, args...)
+	d = append(d, KVString(_appID, l.c.Family), KVString(_instanceID, l.c.Host))
+	if l.name != "" {
+		d = append(d, KVString(_logger, l.name))
+	}
+	if pc, file, line, ok := runtime.Caller(_callerSkip); ok {
+		d = append(d, KVString(_source, file+":"+strconv.Itoa(line)), KVString(_funcName, shortFuncName(pc)))
+	}
+	if lv == _fatalLevel {
+		d = append(d, KVString(_stack, stack()))
+	}
+	l.handler().Log(ctx, lv, d...)
+	if lv == _fatalLevel {
+		l.exit()
+	}
+}
+
+// _exit is replaced in tests.
+var _exit = os.Exit
+
+// exit flushes and closes all handlers then terminates the process.
+func (l *Logger) exit() {
+	l.Close()
+	_exit(1)
+}
+
+// shortFuncName returns the last element of the function name of pc, e.g. main.
+func shortFuncName(pc uintptr) string {
+	fn := runtime.FuncForPC(pc)
+	if fn == nil {
+		return "unknown"
+	}
+	name := fn.Name()
+	return name[strings.LastIndexByte(name, '.')+1:]
+}
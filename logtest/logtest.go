@@ -0,0 +1,258 @@
+// Package logtest provides handlers to assert on the entries logged in tests.
+//
+//	func TestPay(t *testing.T) {
+//		obs := logtest.Install(t)
+//		pay(ctx, 1)
+//		assert.Equal(t, 1, obs.Entries().FilterLevel(log.ErrorLevel).FilterField(log.KVInt("uid", 1)).Len())
+//	}
+package logtest
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hxchjm/log"
+	"github.com/hxchjm/log/core"
+)
+
+// _tbPattern is the format of the entries logged by t.Log.
+const _tbPattern = "%L %s %M"
+
+// the keys added by the log package to every entry.
+const (
+	_level      = "level"
+	_levelValue = "level_value"
+	_time       = "time"
+	_source     = "source"
+	_funcName   = "func"
+	_logger     = "logger"
+	_log        = "log"
+	_appID      = "app_id"
+	_instanceID = "instance_id"
+)
+
+// Entry is an observed entry.
+type Entry struct {
+	Level   log.Level
+	Time    time.Time
+	Message string
+	// Fields are the fields of the call and the bound ones, Context and the ones
+	// added by the log package excluded.
+	Fields []log.D
+	// Context are the fields of the context, see log.Extract.
+	Context []log.D
+	// Caller is the file and line of the call site, e.g. /a/b/c/d.go:23.
+	Caller string
+	// Logger is the name of the logger, see log.Logger.Named.
+	Logger string
+}
+
+// Field returns the value of the field of key and true if any.
+func (e Entry) Field(key string) (interface{}, bool) {
+	for _, f := range e.Fields {
+		if f.Key == key {
+			return fieldValue(f), true
+		}
+	}
+	for _, f := range e.Context {
+		if f.Key == key {
+			return fieldValue(f), true
+		}
+	}
+	return nil, false
+}
+
+// Entries is a list of observed entries.
+type Entries []Entry
+
+// Len returns the number of the entries.
+func (es Entries) Len() int {
+	return len(es)
+}
+
+// Messages returns the messages of the entries.
+func (es Entries) Messages() []string {
+	msgs := make([]string, 0, len(es))
+	for _, e := range es {
+		msgs = append(msgs, e.Message)
+	}
+	return msgs
+}
+
+// Filter returns the entries fn returns true.
+func (es Entries) Filter(fn func(Entry) bool) Entries {
+	var out Entries
+	for _, e := range es {
+		if fn(e) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// FilterLevel returns the entries of lv.
+func (es Entries) FilterLevel(lv log.Level) Entries {
+	return es.Filter(func(e Entry) bool { return e.Level == lv })
+}
+
+// FilterMessage returns the entries of the message msg.
+func (es Entries) FilterMessage(msg string) Entries {
+	return es.Filter(func(e Entry) bool { return e.Message == msg })
+}
+
+// FilterMessageSnippet returns the entries whose message contains snippet.
+func (es Entries) FilterMessageSnippet(snippet string) Entries {
+	return es.Filter(func(e Entry) bool { return strings.Contains(e.Message, snippet) })
+}
+
+// FilterField returns the entries have a field or context field equal to f,
+// e.g. FilterField(log.KVInt("uid", 1)).
+func (es Entries) FilterField(f log.D) Entries {
+	want := fieldValue(f)
+	return es.Filter(func(e Entry) bool {
+		v, ok := e.Field(f.Key)
+		return ok && reflect.DeepEqual(v, want)
+	})
+}
+
+// FilterFieldKey returns the entries have a field or context field of key.
+func (es Entries) FilterFieldKey(key string) Entries {
+	return es.Filter(func(e Entry) bool {
+		_, ok := e.Field(key)
+		return ok
+	})
+}
+
+// Observer is a handler records the entries, it's safe for concurrent use.
+type Observer struct {
+	mu      sync.Mutex
+	entries Entries
+}
+
+// NewObserver create an observer.
+func NewObserver() *Observer {
+	return &Observer{}
+}
+
+// Log records the entry.
+func (o *Observer) Log(ctx context.Context, lv log.Level, args ...log.D) {
+	e := Entry{Level: lv, Context: log.Extract(ctx)}
+	for _, f := range args {
+		switch f.Key {
+		case _time:
+			e.Time, _ = f.Value.(time.Time)
+		case _log:
+			e.Message = f.StringVal
+		case _source:
+			e.Caller = f.StringVal
+		case _logger:
+			e.Logger = f.StringVal
+		case _level, _levelValue, _funcName, _appID, _instanceID:
+		default:
+			if !hasKey(e.Context, f.Key) {
+				e.Fields = append(e.Fields, f)
+			}
+		}
+	}
+	o.mu.Lock()
+	o.entries = append(o.entries, e)
+	o.mu.Unlock()
+}
+
+// SetFormat does nothing.
+func (o *Observer) SetFormat(string) {}
+
+// Close does nothing, the entries are kept.
+func (o *Observer) Close() error {
+	return nil
+}
+
+// Entries returns a copy of the entries in the order they were logged.
+func (o *Observer) Entries() Entries {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append(Entries(nil), o.entries...)
+}
+
+// Len returns the number of the entries.
+func (o *Observer) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.entries)
+}
+
+// TakeAll returns the entries and resets the observer.
+func (o *Observer) TakeAll() Entries {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	es := o.entries
+	o.entries = nil
+	return es
+}
+
+// NewTB create a handler logs the entries by tb.Log, so they are shown with the test
+// which logged them, e.g. go test -v.
+func NewTB(tb testing.TB) log.Handler {
+	return log.NewWriterHandler(tbWriter{tb: tb}, _tbPattern)
+}
+
+// tbWriter writes an entry by a tb.Log call.
+type tbWriter struct {
+	tb testing.TB
+}
+
+func (w tbWriter) Write(p []byte) (int, error) {
+	w.tb.Helper()
+	w.tb.Log(strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+// Install replaces the default logger with a logger of all levels logs to an observer
+// and tb.Log for the test, the old one is restored when the test finishes.
+func Install(tb testing.TB) *Observer {
+	tb.Helper()
+	obs := NewObserver()
+	l, err := log.New(&log.Config{Debug: true, Handlers: []log.Handler{obs, NewTB(tb)}})
+	if err != nil {
+		tb.Fatalf("logtest: log.New() error(%v)", err)
+	}
+	old := log.Default()
+	log.SetDefault(l)
+	tb.Cleanup(func() {
+		log.SetDefault(old)
+	})
+	return obs
+}
+
+func hasKey(fields []log.D, key string) bool {
+	for _, f := range fields {
+		if f.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldValue returns the value of f, integers are int64.
+func fieldValue(f log.D) interface{} {
+	switch f.Type {
+	case core.UintType, core.Uint64Type, core.IntTpye, core.Int64Type:
+		return f.Int64Val
+	case core.StringType:
+		return f.StringVal
+	case core.Float32Type:
+		return math.Float32frombits(uint32(f.Int64Val))
+	case core.Float64Type:
+		return math.Float64frombits(uint64(f.Int64Val))
+	case core.DurationType:
+		return time.Duration(f.Int64Val)
+	case core.BoolType:
+		return f.StringVal == "true"
+	}
+	return f.Value
+}
@@ -0,0 +1,43 @@
+package logtest
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hxchjm/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstall(t *testing.T) {
+	old := log.Default()
+	t.Run("installed", func(t *testing.T) {
+		obs := Install(t)
+		assert.NotEqual(t, old, log.Default())
+
+		ctx := log.NewTraceContext(context.Background(), "t1")
+		log.Debug("starting")
+		log.Infov(ctx, log.KVString("log", "paid"), log.KVInt("uid", 1))
+		log.Named("dao").With(log.KVString("order_id", "o1")).Errorv(ctx, log.KVString("log", "insert failed"), log.KVInt("uid", 2))
+
+		es := obs.Entries()
+		assert.Equal(t, []string{"starting", "paid", "insert failed"}, es.Messages())
+		assert.Equal(t, []string{"insert failed"}, es.FilterLevel(log.ErrorLevel).Messages())
+		assert.Equal(t, []string{"paid"}, es.FilterField(log.KVInt("uid", 1)).Messages())
+		assert.Equal(t, 2, es.FilterField(log.KVString("traceid", "t1")).Len())
+		assert.Equal(t, 1, es.FilterFieldKey("order_id").Len())
+		assert.Equal(t, 1, es.FilterMessage("paid").Len())
+		assert.Equal(t, 1, es.FilterMessageSnippet("failed").Len())
+
+		e := es[2]
+		assert.Equal(t, "dao", e.Logger)
+		assert.True(t, strings.Contains(e.Caller, "logtest_test.go:"), e.Caller)
+		assert.False(t, e.Time.IsZero())
+		assert.Equal(t, []log.D{log.KVString("order_id", "o1"), log.KVInt("uid", 2)}, e.Fields)
+		assert.Contains(t, e.Context, log.KVString("traceid", "t1"))
+
+		assert.Equal(t, 3, obs.TakeAll().Len())
+		assert.Equal(t, 0, obs.Len())
+	})
+	assert.Equal(t, old, log.Default())
+}
@@ -0,0 +1,386 @@
+package log
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"time"
+
+	"github.com/hxchjm/log/core"
+)
+
+// a minimal msgpack codec of the types used by the fluentd forward protocol,
+// see https://github.com/msgpack/msgpack/blob/master/spec.md.
+
+const (
+	// _msgpackMaxLen caps the lengths read from the network, so a corrupt or hostile
+	// peer can't make the decoder allocate gigabytes.
+	_msgpackMaxLen = 16 << 20
+	// _msgpackPrealloc caps the preallocated elements of an array or a map, the rest
+	// are appended as they are decoded.
+	_msgpackPrealloc = 1024
+)
+
+func appendMsgpackUint(buf *core.Buffer, prefix byte, n uint64, size int) {
+	buf.AppendByte(prefix)
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], n)
+	buf.Write(b[8-size:])
+}
+
+func appendMsgpackArray(buf *core.Buffer, n int) {
+	switch {
+	case n < 16:
+		buf.AppendByte(0x90 | byte(n))
+	case n <= math.MaxUint16:
+		appendMsgpackUint(buf, 0xdc, uint64(n), 2)
+	default:
+		appendMsgpackUint(buf, 0xdd, uint64(n), 4)
+	}
+}
+
+func appendMsgpackMap(buf *core.Buffer, n int) {
+	switch {
+	case n < 16:
+		buf.AppendByte(0x80 | byte(n))
+	case n <= math.MaxUint16:
+		appendMsgpackUint(buf, 0xde, uint64(n), 2)
+	default:
+		appendMsgpackUint(buf, 0xdf, uint64(n), 4)
+	}
+}
+
+func appendMsgpackString(buf *core.Buffer, s string) {
+	switch n := len(s); {
+	case n < 32:
+		buf.AppendByte(0xa0 | byte(n))
+	case n <= math.MaxUint8:
+		appendMsgpackUint(buf, 0xd9, uint64(n), 1)
+	case n <= math.MaxUint16:
+		appendMsgpackUint(buf, 0xda, uint64(n), 2)
+	default:
+		appendMsgpackUint(buf, 0xdb, uint64(n), 4)
+	}
+	buf.AppendString(s)
+}
+
+func appendMsgpackBin(buf *core.Buffer, b []byte) {
+	switch n := len(b); {
+	case n <= math.MaxUint8:
+		appendMsgpackUint(buf, 0xc4, uint64(n), 1)
+	case n <= math.MaxUint16:
+		appendMsgpackUint(buf, 0xc5, uint64(n), 2)
+	default:
+		appendMsgpackUint(buf, 0xc6, uint64(n), 4)
+	}
+	buf.Write(b)
+}
+
+func appendMsgpackInt(buf *core.Buffer, n int64) {
+	switch {
+	case n >= 0:
+		appendMsgpackUint64(buf, uint64(n))
+	case n >= -32:
+		buf.AppendByte(byte(n))
+	case n >= math.MinInt8:
+		appendMsgpackUint(buf, 0xd0, uint64(n), 1)
+	case n >= math.MinInt16:
+		appendMsgpackUint(buf, 0xd1, uint64(n), 2)
+	case n >= math.MinInt32:
+		appendMsgpackUint(buf, 0xd2, uint64(n), 4)
+	default:
+		appendMsgpackUint(buf, 0xd3, uint64(n), 8)
+	}
+}
+
+func appendMsgpackUint64(buf *core.Buffer, n uint64) {
+	switch {
+	case n < 128:
+		buf.AppendByte(byte(n))
+	case n <= math.MaxUint8:
+		appendMsgpackUint(buf, 0xcc, n, 1)
+	case n <= math.MaxUint16:
+		appendMsgpackUint(buf, 0xcd, n, 2)
+	case n <= math.MaxUint32:
+		appendMsgpackUint(buf, 0xce, n, 4)
+	default:
+		appendMsgpackUint(buf, 0xcf, n, 8)
+	}
+}
+
+func appendMsgpackFloat64(buf *core.Buffer, f float64) {
+	appendMsgpackUint(buf, 0xcb, math.Float64bits(f), 8)
+}
+
+func appendMsgpackBool(buf *core.Buffer, b bool) {
+	if b {
+		buf.AppendByte(0xc3)
+	} else {
+		buf.AppendByte(0xc2)
+	}
+}
+
+// appendMsgpackEventTime appends t as the EventTime ext of the fluentd forward protocol.
+func appendMsgpackEventTime(buf *core.Buffer, t time.Time) {
+	buf.AppendByte(0xd7)
+	buf.AppendByte(0x00)
+	var b [8]byte
+	binary.BigEndian.PutUint32(b[:4], uint32(t.Unix()))
+	binary.BigEndian.PutUint32(b[4:], uint32(t.Nanosecond()))
+	buf.Write(b[:])
+}
+
+// appendMsgpackField appends the value of f.
+func appendMsgpackField(buf *core.Buffer, f D) {
+	switch f.Type {
+	case core.UintType, core.Uint64Type:
+		appendMsgpackUint64(buf, uint64(f.Int64Val))
+	case core.IntTpye, core.Int64Type:
+		appendMsgpackInt(buf, f.Int64Val)
+	case core.StringType:
+		appendMsgpackString(buf, f.StringVal)
+	case core.Float32Type:
+		appendMsgpackFloat64(buf, float64(math.Float32frombits(uint32(f.Int64Val))))
+	case core.Float64Type:
+		appendMsgpackFloat64(buf, math.Float64frombits(uint64(f.Int64Val)))
+	case core.DurationType:
+		appendMsgpackFloat64(buf, time.Duration(f.Int64Val).Seconds())
+	case core.BoolType:
+		appendMsgpackBool(buf, f.StringVal == "true")
+	default:
+		appendMsgpackValue(buf, f.Value)
+	}
+}
+
+// appendMsgpackValue appends v, the types not of msgpack are encoded by their JSON form.
+func appendMsgpackValue(buf *core.Buffer, v interface{}) {
+	switch val := v.(type) {
+	case nil:
+		buf.AppendByte(0xc0)
+	case bool:
+		appendMsgpackBool(buf, val)
+	case int:
+		appendMsgpackInt(buf, int64(val))
+	case int8:
+		appendMsgpackInt(buf, int64(val))
+	case int16:
+		appendMsgpackInt(buf, int64(val))
+	case int32:
+		appendMsgpackInt(buf, int64(val))
+	case int64:
+		appendMsgpackInt(buf, val)
+	case uint:
+		appendMsgpackUint64(buf, uint64(val))
+	case uint8:
+		appendMsgpackUint64(buf, uint64(val))
+	case uint16:
+		appendMsgpackUint64(buf, uint64(val))
+	case uint32:
+		appendMsgpackUint64(buf, uint64(val))
+	case uint64:
+		appendMsgpackUint64(buf, val)
+	case float32:
+		appendMsgpackFloat64(buf, float64(val))
+	case float64:
+		appendMsgpackFloat64(buf, val)
+	case string:
+		appendMsgpackString(buf, val)
+	case []byte:
+		appendMsgpackBin(buf, val)
+	case time.Time:
+		appendMsgpackString(buf, val.Format(time.RFC3339Nano))
+	case time.Duration:
+		appendMsgpackFloat64(buf, val.Seconds())
+	case error:
+		appendMsgpackString(buf, val.Error())
+	case fmt.Stringer:
+		appendMsgpackString(buf, val.String())
+	case map[string]interface{}:
+		appendMsgpackMap(buf, len(val))
+		for k, e := range val {
+			appendMsgpackString(buf, k)
+			appendMsgpackValue(buf, e)
+		}
+	case map[string]string:
+		appendMsgpackMap(buf, len(val))
+		for k, e := range val {
+			appendMsgpackString(buf, k)
+			appendMsgpackString(buf, e)
+		}
+	case []interface{}:
+		appendMsgpackArray(buf, len(val))
+		for _, e := range val {
+			appendMsgpackValue(buf, e)
+		}
+	case []string:
+		appendMsgpackArray(buf, len(val))
+		for _, e := range val {
+			appendMsgpackString(buf, e)
+		}
+	default:
+		switch reflect.Indirect(reflect.ValueOf(v)).Kind() {
+		case reflect.Struct, reflect.Map, reflect.Slice, reflect.Array:
+			var generic interface{}
+			if b, err := json.Marshal(v); err == nil && json.Unmarshal(b, &generic) == nil {
+				appendMsgpackValue(buf, generic)
+				return
+			}
+		}
+		appendMsgpackString(buf, fmt.Sprint(v))
+	}
+}
+
+// decodeMsgpack decodes a value, maps are map[string]interface{}, integers are int64
+// or uint64 and the EventTime ext is time.Time.
+func decodeMsgpack(r *bufio.Reader) (interface{}, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c >= 0x80 && c <= 0x8f:
+		return decodeMsgpackMap(r, int(c&0x0f))
+	case c >= 0x90 && c <= 0x9f:
+		return decodeMsgpackArray(r, int(c&0x0f))
+	case c >= 0xa0 && c <= 0xbf:
+		return decodeMsgpackBytes(r, int(c&0x1f), true)
+	}
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := readMsgpackUint(r, 1<<(c-0xc4))
+		if err != nil {
+			return nil, err
+		}
+		return decodeMsgpackBytes(r, int(n), false)
+	case 0xca:
+		n, err := readMsgpackUint(r, 4)
+		return float64(math.Float32frombits(uint32(n))), err
+	case 0xcb:
+		n, err := readMsgpackUint(r, 8)
+		return math.Float64frombits(n), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return readMsgpackUint(r, 1<<(c-0xcc))
+	case 0xd0:
+		n, err := readMsgpackUint(r, 1)
+		return int64(int8(n)), err
+	case 0xd1:
+		n, err := readMsgpackUint(r, 2)
+		return int64(int16(n)), err
+	case 0xd2:
+		n, err := readMsgpackUint(r, 4)
+		return int64(int32(n)), err
+	case 0xd3:
+		n, err := readMsgpackUint(r, 8)
+		return int64(n), err
+	case 0xd7:
+		typ, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		n, err := readMsgpackUint(r, 8)
+		if err != nil {
+			return nil, err
+		}
+		if typ != 0 {
+			return nil, fmt.Errorf("msgpack: unknown ext type %d", typ)
+		}
+		return time.Unix(int64(n>>32), int64(n&math.MaxUint32)), nil
+	case 0xd9, 0xda, 0xdb:
+		n, err := readMsgpackUint(r, 1<<(c-0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return decodeMsgpackBytes(r, int(n), true)
+	case 0xdc, 0xdd:
+		n, err := readMsgpackUint(r, 2<<(c-0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return decodeMsgpackArray(r, int(n))
+	case 0xde, 0xdf:
+		n, err := readMsgpackUint(r, 2<<(c-0xde))
+		if err != nil {
+			return nil, err
+		}
+		return decodeMsgpackMap(r, int(n))
+	}
+	return nil, fmt.Errorf("msgpack: unsupported type 0x%x", c)
+}
+
+func readMsgpackUint(r *bufio.Reader, size int) (uint64, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(r, b[8-size:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(b[:]), nil
+}
+
+func decodeMsgpackBytes(r *bufio.Reader, n int, str bool) (interface{}, error) {
+	if n > _msgpackMaxLen {
+		return nil, fmt.Errorf("msgpack: length %d exceeds %d", n, _msgpackMaxLen)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	if str {
+		return string(b), nil
+	}
+	return b, nil
+}
+
+func decodeMsgpackArray(r *bufio.Reader, n int) (interface{}, error) {
+	if n > _msgpackMaxLen {
+		return nil, fmt.Errorf("msgpack: length %d exceeds %d", n, _msgpackMaxLen)
+	}
+	a := make([]interface{}, 0, msgpackPrealloc(n))
+	for i := 0; i < n; i++ {
+		v, err := decodeMsgpack(r)
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, v)
+	}
+	return a, nil
+}
+
+func decodeMsgpackMap(r *bufio.Reader, n int) (interface{}, error) {
+	if n > _msgpackMaxLen {
+		return nil, fmt.Errorf("msgpack: length %d exceeds %d", n, _msgpackMaxLen)
+	}
+	m := make(map[string]interface{}, msgpackPrealloc(n))
+	for i := 0; i < n; i++ {
+		k, err := decodeMsgpack(r)
+		if err != nil {
+			return nil, err
+		}
+		v, err := decodeMsgpack(r)
+		if err != nil {
+			return nil, err
+		}
+		m[fmt.Sprint(k)] = v
+	}
+	return m, nil
+}
+
+// msgpackPrealloc returns the capacity preallocated for n elements.
+func msgpackPrealloc(n int) int {
+	if n > _msgpackPrealloc {
+		return _msgpackPrealloc
+	}
+	return n
+}
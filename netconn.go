@@ -0,0 +1,146 @@
+package log
+
+import (
+	"errors"
+	"io"
+	stdlog "log"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// netConn is the connection to a log server shared by a handler and its children,
+// it's redialed with backoff after a failure and the messages are written to the
+// fallback while the server is down.
+type netConn struct {
+	dial    func() (net.Conn, error)
+	timeout time.Duration
+
+	mu      sync.Mutex
+	conn    net.Conn
+	backoff time.Duration
+	retry   time.Time
+	closed  bool
+	// fallback receives the messages while the server is down.
+	fallback io.Writer
+	stdlog   *stdlog.Logger
+	// now returns the current time of the backoff, it's replaced by the tests.
+	now func() time.Time
+}
+
+// newNetConn create a connection dialed by dial on the first message, prefix is the
+// prefix of the errors printed to stderr, e.g. "log-syslog ".
+func newNetConn(prefix string, timeout time.Duration, dial func() (net.Conn, error)) *netConn {
+	return &netConn{
+		dial:     dial,
+		timeout:  timeout,
+		fallback: os.Stderr,
+		stdlog:   stdlog.New(os.Stderr, prefix, stdlog.LstdFlags),
+		now:      time.Now,
+	}
+}
+
+var (
+	// errNetConnDown is returned by send if the server is down or in the backoff.
+	errNetConnDown = errors.New("log: server is down")
+	// errNetConnClosed is returned by send after close.
+	errNetConnClosed = errors.New("log: connection closed")
+)
+
+// write writes msg by frame, msg is written to the fallback if it fails, see send.
+func (c *netConn) write(msg []byte, frame func(conn net.Conn, msg []byte) error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || c.send(msg, frame) == nil {
+		return
+	}
+	if len(msg) == 0 || msg[len(msg)-1] != '\n' {
+		msg = append(msg, '\n')
+	}
+	c.fallback.Write(msg)
+}
+
+// sendMsg writes msg by frame and returns the error, so the caller can keep msg and
+// resend it later, see send.
+func (c *netConn) sendMsg(msg []byte, frame func(conn net.Conn, msg []byte) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return errNetConnClosed
+	}
+	return c.send(msg, frame)
+}
+
+// send writes msg by frame, it redials once if the connection is broken, and waits for
+// the backoff before redialing if it fails again, it must be called with mu held.
+func (c *netConn) send(msg []byte, frame func(conn net.Conn, msg []byte) error) error {
+	err := errNetConnDown
+	for i := 0; i < 2; i++ {
+		if c.conn == nil && !c.redial() {
+			return err
+		}
+		c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+		if err = frame(c.conn, msg); err == nil {
+			c.backoff = 0
+			return nil
+		}
+		c.stdlog.Printf("conn.Write(%d bytes) error(%v)\n", len(msg), err)
+		c.conn.Close()
+		c.conn = nil
+	}
+	c.backoff = nextBackoff(c.backoff)
+	c.retry = c.now().Add(c.backoff)
+	return err
+}
+
+// retryNow ends the backoff, so the next message redials at once.
+func (c *netConn) retryNow() {
+	c.mu.Lock()
+	c.retry = time.Time{}
+	c.mu.Unlock()
+}
+
+// redial connects to the server unless it's in the backoff after a failure.
+func (c *netConn) redial() bool {
+	if c.now().Before(c.retry) {
+		return false
+	}
+	conn, err := c.dial()
+	if err != nil {
+		c.backoff = nextBackoff(c.backoff)
+		c.retry = c.now().Add(c.backoff)
+		c.stdlog.Printf("dial error(%v), retry after %v\n", err, c.backoff)
+		return false
+	}
+	c.conn = conn
+	return true
+}
+
+// close closes the connection, the messages written after close are discarded.
+func (c *netConn) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// writeConn writes msg as is, it's the frame of the newline delimited messages.
+func writeConn(conn net.Conn, msg []byte) error {
+	_, err := conn.Write(msg)
+	return err
+}
+
+// isStream reports whether conn is a stream connection, e.g. tcp and unix.
+func isStream(conn net.Conn) bool {
+	switch conn.RemoteAddr().Network() {
+	case "udp", "udp4", "udp6", "unixgram":
+		return false
+	}
+	return true
+}
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"io"
 	"path"
-	"runtime"
 	"strings"
 	"sync"
 	"time"
@@ -26,6 +25,7 @@ var patternMap = map[string]func(map[string]interface{}) string{
 	"S": longSource,
 	"s": shortSource,
 	"M": message,
+	"n": keyFactory(_logger),
 }
 
 // newPatternRender new pattern render
@@ -113,26 +113,23 @@ func keyFactory(key string) func(map[string]interface{}) string {
 	}
 }
 
-func funcSource(map[string]interface{}) string {
-	pc, _, _, ok := runtime.Caller(5)
-	if ok {
-		path := runtime.FuncForPC(pc).Name()
-		funcName := strings.Split(path, ".")
-		return funcName[len(funcName)-1]
+func funcSource(d map[string]interface{}) string {
+	if fn, ok := d[_funcName].(string); ok {
+		return fn
 	}
 	return "unknown"
 }
 
-func longSource(map[string]interface{}) string {
-	if _, file, lineNo, ok := runtime.Caller(5); ok {
-		return fmt.Sprintf("%s:%d", file, lineNo)
+func longSource(d map[string]interface{}) string {
+	if fn, ok := d[_source].(string); ok {
+		return fn
 	}
 	return "unknown:0"
 }
 
-func shortSource(map[string]interface{}) string {
-	if _, file, lineNo, ok := runtime.Caller(5); ok {
-		return fmt.Sprintf("%s:%d", path.Base(file), lineNo)
+func shortSource(d map[string]interface{}) string {
+	if fn, ok := d[_source].(string); ok {
+		return path.Base(fn)
 	}
 	return "unknown:0"
 }
@@ -155,7 +152,7 @@ func shortDate(map[string]interface{}) string {
 
 func isInternalKey(k string) bool {
 	switch k {
-	case _level, _levelValue, _time, _source, _instanceID, _appID, _deplyEnv, _zone:
+	case _level, _levelValue, _time, _source, _funcName, _logger, _instanceID, _appID, _deplyEnv, _zone:
 		return true
 	}
 	return false
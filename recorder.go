@@ -0,0 +1,235 @@
+package log
+
+import (
+	"context"
+	"io"
+	stdlog "log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hxchjm/log/core"
+)
+
+const (
+	_recorderEntries  = 1000
+	_recorderInterval = 10 * time.Second
+	_recorderTime     = "20060102-150405.000000"
+)
+
+// RecorderConfig flight recorder config, see NewRecorder.
+type RecorderConfig struct {
+	// Entries max number of the entries kept of each level,
+	// 0 means 1000 unless Bytes is set.
+	Entries int
+	// Bytes max total size of the entries kept of each level, 0 means no limit.
+	Bytes int
+	// Dir the entries are dumped into a file of Dir when an ERROR or FATAL entry arrives,
+	// e.g. flight-20060102-150405.000000.log, empty disables the automatic dump.
+	Dir string
+	// Interval min interval between the automatic dumps of ERROR entries, FATAL entries
+	// are always dumped, 0 means 10s.
+	Interval time.Duration
+	// Format of the entries, "json" or a pattern see SetFormat, empty means the file format.
+	Format string
+}
+
+// RecorderHandler is a flight recorder keeps the last entries of each level in memory,
+// they are dumped by Dump or ServeHTTP, and into a file when an ERROR or FATAL entry arrives,
+// which is emptied after the automatic dump.
+// To keep the debug entries only in memory, enable Config.Debug and set the minimum level
+// of the other handlers to INFO, e.g. Config.StdoutLevel.
+type RecorderHandler struct {
+	enc    entryEncoder
+	fields []D
+	level  *atomicLevel
+	r      *recorder
+}
+
+// recorder is the entries shared by a RecorderHandler and its children.
+type recorder struct {
+	c      RecorderConfig
+	stdlog *stdlog.Logger
+
+	mu       sync.Mutex
+	seq      uint64
+	rings    [_offLevel]recordRing
+	lastDump time.Time
+}
+
+// recordRing is the entries of a level, the oldest first.
+type recordRing struct {
+	entries []recorded
+	bytes   int
+}
+
+type recorded struct {
+	seq uint64
+	buf *core.Buffer
+}
+
+// NewRecorder create a flight recorder handles all levels, nil conf means the defaults.
+func NewRecorder(conf *RecorderConfig) *RecorderHandler {
+	c := RecorderConfig{}
+	if conf != nil {
+		c = *conf
+	}
+	if c.Entries <= 0 && c.Bytes <= 0 {
+		c.Entries = _recorderEntries
+	}
+	if c.Interval <= 0 {
+		c.Interval = _recorderInterval
+	}
+	if c.Format == "" {
+		c.Format = _filePattern
+	}
+	return &RecorderHandler{
+		enc:   newEntryEncoder(c.Format, nil),
+		level: newAtomicLevel(_debugLevel),
+		r: &recorder{
+			c:      c,
+			stdlog: stdlog.New(os.Stderr, "log-recorder ", stdlog.LstdFlags),
+		},
+	}
+}
+
+// Log keeps the entry, the oldest entries of the level are discarded beyond the limits.
+func (h *RecorderHandler) Log(ctx context.Context, lv Level, args ...D) {
+	buf := core.GetPool()
+	if err := h.enc.Encode(buf, args); err != nil {
+		buf.Free()
+		return
+	}
+	h.r.add(lv, buf)
+	if lv >= _errorLevel && h.r.c.Dir != "" {
+		h.r.autoDump(lv)
+	}
+}
+
+// With returns a recorder shares the entries and attaches fields to every entry.
+func (h *RecorderHandler) With(fields ...D) Handler {
+	bound := append(h.fields[:len(h.fields):len(h.fields)], fields...)
+	return &RecorderHandler{enc: h.enc.With(fields), fields: bound, level: h.level, r: h.r}
+}
+
+// SetLevel set the minimum level of the recorder.
+func (h *RecorderHandler) SetLevel(lv Level) {
+	h.level.SetLevel(lv)
+}
+
+// Enabled reports whether lv is at or above the minimum level.
+func (h *RecorderHandler) Enabled(lv Level) bool {
+	return h.level.Enabled(lv)
+}
+
+// Close discards the entries.
+func (h *RecorderHandler) Close() error {
+	h.r.mu.Lock()
+	h.r.reset()
+	h.r.mu.Unlock()
+	return nil
+}
+
+// SetFormat is ignored, the format is RecorderConfig.Format.
+func (h *RecorderHandler) SetFormat(string) {}
+
+// Dump writes the entries of all levels to w in the order they were logged.
+func (h *RecorderHandler) Dump(w io.Writer) error {
+	buf := h.r.dump(false)
+	defer buf.Free()
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// ServeHTTP dumps the entries, e.g. http.Handle("/debug/log/flight", recorder).
+func (h *RecorderHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	h.Dump(w)
+}
+
+func (r *recorder) add(lv Level, buf *core.Buffer) {
+	if lv >= _offLevel {
+		lv = _fatalLevel
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.c.Bytes > 0 && buf.Len() > r.c.Bytes {
+		buf.Free()
+		return
+	}
+	r.seq++
+	ring := &r.rings[lv]
+	ring.entries = append(ring.entries, recorded{seq: r.seq, buf: buf})
+	ring.bytes += buf.Len()
+	for (r.c.Entries > 0 && len(ring.entries) > r.c.Entries) || (r.c.Bytes > 0 && ring.bytes > r.c.Bytes) {
+		oldest := ring.entries[0]
+		ring.entries[0] = recorded{}
+		ring.entries = ring.entries[1:]
+		ring.bytes -= oldest.buf.Len()
+		oldest.buf.Free()
+	}
+}
+
+// dump returns the entries of all levels in the order they were logged,
+// the entries are discarded if reset.
+func (r *recorder) dump(reset bool) *core.Buffer {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var all []recorded
+	for i := range r.rings {
+		all = append(all, r.rings[i].entries...)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].seq < all[j].seq })
+	out := core.GetPool()
+	for _, e := range all {
+		out.Write(e.buf.Bytes())
+	}
+	if reset {
+		r.reset()
+	}
+	return out
+}
+
+// reset discards the entries, r.mu must be held.
+func (r *recorder) reset() {
+	for i := range r.rings {
+		for _, e := range r.rings[i].entries {
+			e.buf.Free()
+		}
+		r.rings[i] = recordRing{}
+	}
+}
+
+// autoDump dumps the entries into a file of Dir, ERROR entries are dumped at most
+// once per Interval.
+func (r *recorder) autoDump(lv Level) {
+	now := time.Now()
+	r.mu.Lock()
+	if lv < _fatalLevel && now.Sub(r.lastDump) < r.c.Interval {
+		r.mu.Unlock()
+		return
+	}
+	r.lastDump = now
+	r.mu.Unlock()
+
+	buf := r.dump(true)
+	defer buf.Free()
+	name := filepath.Join(r.c.Dir, "flight-"+now.Format(_recorderTime)+".log")
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		r.stdlog.Printf("os.OpenFile(%s) error(%v)\n", name, err)
+		return
+	}
+	if _, err = f.Write(buf.Bytes()); err != nil {
+		r.stdlog.Printf("f.Write(%s) error(%v)\n", name, err)
+	}
+	f.Close()
+}
@@ -0,0 +1,73 @@
+package log
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecorder(t *testing.T) {
+	rec := NewRecorder(&RecorderConfig{Entries: 2, Format: "%L %M"})
+	l, err := New(&Config{Debug: true, StdoutLevel: "OFF", Handlers: []Handler{rec}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	l.Debug("d1")
+	l.Info("i1")
+	l.Debug("d2")
+	l.With(KVInt("uid", 1)).Debug("d3")
+	l.Warn("w1")
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, rec.Dump(buf))
+	assert.Equal(t, "INFO i1\nDEBUG d2\nDEBUG uid=1 d3\nWARN w1\n", buf.String())
+
+	req := httptest.NewRequest(http.MethodGet, "/debug/log/flight", nil)
+	w := httptest.NewRecorder()
+	rec.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, buf.String(), w.Body.String())
+
+	rec = NewRecorder(&RecorderConfig{Bytes: 20, Format: "%M"})
+	l = newLogger(&Config{Debug: true}, newHandlers(nil, rec))
+	l.Debug("0123456789")
+	l.Debug("abcdefghij")
+	l.Debug(strings.Repeat("x", 30))
+	buf.Reset()
+	rec.Dump(buf)
+	assert.Equal(t, "abcdefghij\n", buf.String())
+}
+
+func TestRecorderAutoDump(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log-recorder")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	rec := NewRecorder(&RecorderConfig{Dir: dir, Format: "%L %M"})
+	l := newLogger(&Config{Debug: true}, newHandlers(nil, rec))
+	l.Debug("connecting")
+	l.Info("retry")
+	l.Error("failed")
+	l.Debug("after")
+	l.Error("failed again")
+
+	files, err := filepath.Glob(filepath.Join(dir, "flight-*.log"))
+	if !assert.NoError(t, err) || !assert.Len(t, files, 1) {
+		return
+	}
+	b, err := ioutil.ReadFile(files[0])
+	if assert.NoError(t, err) {
+		assert.Equal(t, "DEBUG connecting\nINFO retry\nERROR failed\n", string(b))
+	}
+	buf := &bytes.Buffer{}
+	rec.Dump(buf)
+	assert.Equal(t, "DEBUG after\nERROR failed again\n", buf.String())
+}
@@ -0,0 +1,375 @@
+package log
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hxchjm/log/core"
+)
+
+// value detectors of RedactConfig.
+const (
+	DetectCard   = "card"
+	DetectPhone  = "phone"
+	DetectEmail  = "email"
+	DetectBearer = "bearer"
+)
+
+// RedactConfig is the rules to redact sensitive data before the entries reach any handler,
+// the fields passed by the caller are never modified.
+type RedactConfig struct {
+	// Keys of sensitive fields, the value of a matched key is masked as a whole, keys of
+	// nested maps and structs included. A key is a case-insensitive glob e.g. "*password*",
+	// or a regexp wrapped in slashes e.g. "/^(access|refresh)_token$/".
+	Keys []string
+	// Detectors find sensitive data inside the log message and other string values,
+	// any of "card", "phone", "email" and "bearer". A phone number has a leading + and
+	// country code or is separated by spaces or dashes, e.g. +86 13800138000 and
+	// 138-0013-8000, so the plain digit runs such as timestamps and ids are kept.
+	Detectors []string
+	// Keep the last N characters of a masked value, e.g. 4 for ************1234,
+	// 0 means replace the value with ***.
+	Keep int
+	// HashKey enables keyed-hash mode, a masked value is replaced by its HMAC-SHA256 under
+	// HashKey e.g. hmac:5d41402abc4b2a76, so equal values can still be correlated.
+	HashKey string
+}
+
+// _redacted is the mask of a value without Keep and HashKey.
+const _redacted = "***"
+
+var (
+	_cardRegexp   = regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`)
+	_phoneRegexp  = regexp.MustCompile(`\+\d{1,3}[ -]?\d{2,4}(?:[ -]?\d{3,4}){2}\b|\b\d{3}[ -]\d{3,4}[ -]\d{4}\b`)
+	_emailRegexp  = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	_bearerRegexp = regexp.MustCompile(`(?i)(bearer\s+)([A-Za-z0-9\-._~+/]+=*)`)
+)
+
+// redactor redacts the fields of entries, it's immutable and safe for concurrent use.
+type redactor struct {
+	exact     map[string]struct{}
+	globs     []string
+	regexps   []*regexp.Regexp
+	detectors []func(string) string
+	keep      int
+	hashKey   []byte
+	// deep reports whether the KV objects are redacted by their JSON form, which is only
+	// needed by the key patterns and the detectors.
+	deep bool
+}
+
+// newRedactor create a redactor from the legacy Config.Filter and conf,
+// nil is returned if there is nothing to redact.
+func newRedactor(filters []string, conf *RedactConfig) (*redactor, error) {
+	if len(filters) == 0 && conf == nil {
+		return nil, nil
+	}
+	r := &redactor{exact: make(map[string]struct{})}
+	for _, k := range filters {
+		r.exact[k] = struct{}{}
+	}
+	if conf == nil {
+		return r, nil
+	}
+	for _, k := range conf.Keys {
+		if len(k) > 1 && strings.HasPrefix(k, "/") && strings.HasSuffix(k, "/") {
+			re, err := regexp.Compile(k[1 : len(k)-1])
+			if err != nil {
+				return nil, fmt.Errorf("log: invalid redact key %q: %v", k, err)
+			}
+			r.regexps = append(r.regexps, re)
+			continue
+		}
+		if _, err := path.Match(k, ""); err != nil {
+			return nil, fmt.Errorf("log: invalid redact key %q: %v", k, err)
+		}
+		r.globs = append(r.globs, strings.ToLower(k))
+	}
+	for _, name := range conf.Detectors {
+		switch name {
+		case DetectCard:
+			r.detectors = append(r.detectors, r.redactCard)
+		case DetectPhone:
+			r.detectors = append(r.detectors, r.replacer(_phoneRegexp))
+		case DetectEmail:
+			r.detectors = append(r.detectors, r.replacer(_emailRegexp))
+		case DetectBearer:
+			r.detectors = append(r.detectors, r.redactBearer)
+		default:
+			return nil, fmt.Errorf("log: unknown redact detector %q", name)
+		}
+	}
+	r.keep = conf.Keep
+	r.deep = len(r.globs) != 0 || len(r.regexps) != 0 || len(r.detectors) != 0
+	if conf.HashKey != "" {
+		r.hashKey = []byte(conf.HashKey)
+	}
+	return r, nil
+}
+
+// redact returns d with sensitive data redacted, d is copied before the first change.
+func (r *redactor) redact(d []D) []D {
+	copied := false
+	for i := range d {
+		if isInternalKey(d[i].Key) {
+			continue
+		}
+		f, ok := r.field(d[i])
+		if !ok {
+			continue
+		}
+		if !copied {
+			d = append([]D(nil), d...)
+			copied = true
+		}
+		d[i] = f
+	}
+	return d
+}
+
+// field returns the redacted field and true if f contains sensitive data.
+func (r *redactor) field(f D) (D, bool) {
+	if r.sensitiveKey(f.Key) {
+		return KVString(f.Key, r.mask(fieldString(f))), true
+	}
+	switch f.Type {
+	case core.StringType:
+		if s, ok := r.detect(f.StringVal); ok {
+			return KVString(f.Key, s), true
+		}
+		return f, false
+	case core.UnknownType:
+		if v, ok := r.value(f.Value); ok {
+			return KV(f.Key, v), true
+		}
+	}
+	return f, false
+}
+
+// value redacts the nested value v, returns a copy and true if v contains sensitive data.
+func (r *redactor) value(v interface{}) (interface{}, bool) {
+	switch val := v.(type) {
+	case nil, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return v, false
+	case string:
+		return r.detectValue(v, val)
+	case []byte:
+		return r.detectValue(v, string(val))
+	case error:
+		return r.detectValue(v, val.Error())
+	case fmt.Stringer:
+		return r.detectValue(v, val.String())
+	case map[string]string:
+		var out map[string]string
+		for k, s := range val {
+			if ns, ok := r.nested(k, s); ok {
+				if out == nil {
+					out = make(map[string]string, len(val))
+					for ck, cv := range val {
+						out[ck] = cv
+					}
+				}
+				out[k] = ns.(string)
+			}
+		}
+		if out == nil {
+			return v, false
+		}
+		return out, true
+	case map[string]interface{}:
+		var out map[string]interface{}
+		for k, e := range val {
+			if ne, ok := r.nested(k, e); ok {
+				if out == nil {
+					out = make(map[string]interface{}, len(val))
+					for ck, cv := range val {
+						out[ck] = cv
+					}
+				}
+				out[k] = ne
+			}
+		}
+		if out == nil {
+			return v, false
+		}
+		return out, true
+	case []string:
+		var out []string
+		for i, s := range val {
+			if ns, ok := r.detect(s); ok {
+				if out == nil {
+					out = append([]string(nil), val...)
+				}
+				out[i] = ns
+			}
+		}
+		if out == nil {
+			return v, false
+		}
+		return out, true
+	case []interface{}:
+		var out []interface{}
+		for i, e := range val {
+			if ne, ok := r.value(e); ok {
+				if out == nil {
+					out = append([]interface{}(nil), val...)
+				}
+				out[i] = ne
+			}
+		}
+		if out == nil {
+			return v, false
+		}
+		return out, true
+	}
+	if !r.deep {
+		return v, false
+	}
+	switch reflect.Indirect(reflect.ValueOf(v)).Kind() {
+	case reflect.Struct, reflect.Map, reflect.Slice, reflect.Array:
+		// KV objects are redacted by their JSON form, which is how the encoders see them.
+		b, err := json.Marshal(v)
+		if err != nil {
+			return v, false
+		}
+		var generic interface{}
+		if err = json.Unmarshal(b, &generic); err != nil {
+			return v, false
+		}
+		return r.value(generic)
+	}
+	return v, false
+}
+
+// detectValue runs the detectors on s, the string form of v.
+func (r *redactor) detectValue(v interface{}, s string) (interface{}, bool) {
+	if out, ok := r.detect(s); ok {
+		return out, true
+	}
+	return v, false
+}
+
+// nested redacts the value e of key k inside a map.
+func (r *redactor) nested(k string, e interface{}) (interface{}, bool) {
+	if r.sensitiveKey(k) {
+		if s, ok := e.(string); ok {
+			return r.mask(s), true
+		}
+		return r.mask(fmt.Sprint(e)), true
+	}
+	return r.value(e)
+}
+
+func (r *redactor) sensitiveKey(k string) bool {
+	if _, ok := r.exact[k]; ok {
+		return true
+	}
+	if len(r.globs) != 0 {
+		lower := strings.ToLower(k)
+		for _, g := range r.globs {
+			if ok, _ := path.Match(g, lower); ok {
+				return true
+			}
+		}
+	}
+	for _, re := range r.regexps {
+		if re.MatchString(k) {
+			return true
+		}
+	}
+	return false
+}
+
+// detect runs the detectors on s, returns the redacted s and true if anything found.
+func (r *redactor) detect(s string) (string, bool) {
+	if len(r.detectors) == 0 {
+		return s, false
+	}
+	out := s
+	for _, fn := range r.detectors {
+		out = fn(out)
+	}
+	if out == s {
+		return s, false
+	}
+	return out, true
+}
+
+// mask masks the whole s by the Keep and HashKey rules.
+func (r *redactor) mask(s string) string {
+	if r.hashKey != nil {
+		mac := hmac.New(sha256.New, r.hashKey)
+		mac.Write([]byte(s))
+		return "hmac:" + hex.EncodeToString(mac.Sum(nil))[:16]
+	}
+	n := utf8.RuneCountInString(s)
+	if r.keep <= 0 || n <= r.keep {
+		return _redacted
+	}
+	runes := []rune(s)
+	return strings.Repeat("*", n-r.keep) + string(runes[n-r.keep:])
+}
+
+func (r *redactor) replacer(re *regexp.Regexp) func(string) string {
+	return func(s string) string {
+		return re.ReplaceAllStringFunc(s, r.mask)
+	}
+}
+
+// redactCard masks the digit sequences which pass the Luhn check.
+func (r *redactor) redactCard(s string) string {
+	return _cardRegexp.ReplaceAllStringFunc(s, func(m string) string {
+		digits := strings.NewReplacer(" ", "", "-", "").Replace(m)
+		if !luhn(digits) {
+			return m
+		}
+		return r.mask(digits)
+	})
+}
+
+// redactBearer masks the token and keeps the "Bearer " prefix.
+func (r *redactor) redactBearer(s string) string {
+	return _bearerRegexp.ReplaceAllStringFunc(s, func(m string) string {
+		sub := _bearerRegexp.FindStringSubmatch(m)
+		return sub[1] + r.mask(sub[2])
+	})
+}
+
+// luhn reports whether the digits pass the Luhn checksum of card numbers.
+func luhn(digits string) bool {
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		n := int(digits[i] - '0')
+		if double {
+			if n *= 2; n > 9 {
+				n -= 9
+			}
+		}
+		sum += n
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// fieldString returns the string form of the value of f.
+func fieldString(f D) string {
+	if f.Type == core.StringType || f.Type == core.BoolType {
+		return f.StringVal
+	}
+	for _, v := range toMap(f) {
+		if s, ok := v.(string); ok {
+			return s
+		}
+		return fmt.Sprint(v)
+	}
+	return ""
+}
@@ -0,0 +1,108 @@
+package log
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testUser struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+func TestRedactKeys(t *testing.T) {
+	r, err := newRedactor([]string{"token"}, &RedactConfig{Keys: []string{"*password*", "/^card_(no|cvv)$/"}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	fields := []D{
+		KVString(_log, "login"),
+		KVString("token", "abc"),
+		KVString("UserPassword", "123456"),
+		KVInt("card_cvv", 123),
+		KVString("card_type", "visa"),
+		KV("user", testUser{Name: "tom", Password: "123456"}),
+		KV("params", map[string]interface{}{"uid": 1, "password": "123456"}),
+	}
+	origin := append([]D(nil), fields...)
+	got := toMap(r.redact(fields)...)
+
+	assert.Equal(t, origin, fields, "the fields of the caller must not be mutated")
+	assert.Equal(t, "login", got[_log])
+	assert.Equal(t, "***", got["token"])
+	assert.Equal(t, "***", got["UserPassword"])
+	assert.Equal(t, "***", got["card_cvv"])
+	assert.Equal(t, "visa", got["card_type"])
+	assert.Equal(t, map[string]interface{}{"name": "tom", "password": "***"}, got["user"])
+	assert.Equal(t, map[string]interface{}{"uid": 1, "password": "***"}, got["params"])
+
+	_, err = newRedactor(nil, &RedactConfig{Keys: []string{"/(/"}})
+	assert.Error(t, err)
+	_, err = newRedactor(nil, &RedactConfig{Detectors: []string{"ssn"}})
+	assert.Error(t, err)
+}
+
+func TestRedactFilterOnly(t *testing.T) {
+	r, err := newRedactor([]string{"token"}, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	user := testUser{Name: "tom", Password: "123456"}
+	got := r.redact([]D{KVString("token", "abc"), KV("user", user)})
+	assert.Equal(t, KVString("token", "***"), got[0])
+	assert.Equal(t, user, got[1].Value, "the objects are passed through without nested rules")
+}
+
+func TestRedactDetectors(t *testing.T) {
+	r, err := newRedactor(nil, &RedactConfig{
+		Detectors: []string{DetectCard, DetectPhone, DetectEmail, DetectBearer},
+		Keep:      4,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"pay by 4111 1111 1111 1111 ok", "pay by ************1111 ok"},
+		{"order 1234567890123 is not a card", "order 1234567890123 is not a card"},
+		{"call 138-0013-8000 now", "call *********8000 now"},
+		{"call +86 13800138000 now", "call ***********8000 now"},
+		{"call 138 0013 8000 now", "call *********8000 now"},
+		{"order 1697000000 created at ts=1697123456 uid=12345678901", "order 1697000000 created at ts=1697123456 uid=12345678901"},
+		{"retry at 2023-10-12 10:00:00", "retry at 2023-10-12 10:00:00"},
+		{"mail tom@example.com", "mail ***********.com"},
+		{"Authorization: Bearer eyJhbGciOi.abc", "Authorization: Bearer **********.abc"},
+		{"nothing here", "nothing here"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got := toMap(r.redact([]D{KVString(_log, tt.in)})...)
+			assert.Equal(t, tt.want, got[_log])
+		})
+	}
+
+	got := toMap(r.redact([]D{
+		KV("err", errors.New("send to tom@example.com failed")),
+		KV("emails", []string{"a", "tom@example.com"}),
+	})...)
+	assert.Equal(t, "send to ***********.com failed", got["err"])
+	assert.Equal(t, []string{"a", "***********.com"}, got["emails"])
+}
+
+func TestRedactHash(t *testing.T) {
+	r, err := newRedactor(nil, &RedactConfig{Keys: []string{"phone"}, HashKey: "secret"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	a := toMap(r.redact([]D{KVString("phone", "13800138000")})...)
+	b := toMap(r.redact([]D{KVString("phone", "13800138000")})...)
+	c := toMap(r.redact([]D{KVString("phone", "13800138001")})...)
+	assert.True(t, strings.HasPrefix(a["phone"].(string), "hmac:"))
+	assert.Equal(t, a["phone"], b["phone"])
+	assert.NotEqual(t, a["phone"], c["phone"])
+}
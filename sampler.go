@@ -0,0 +1,247 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	_samplerTick       = time.Second
+	_samplerFirst      = 100
+	_samplerThereafter = 100
+	_samplerSummary    = time.Minute
+	// _samplerCounters is the number of counters of each level, the messages of the
+	// same hash share a counter.
+	_samplerCounters = 4096
+)
+
+// SamplerConfig sampler handler config, see Sample.
+type SamplerConfig struct {
+	// Tick the counters of the messages are reset every Tick, 0 means 1s.
+	Tick time.Duration
+	// First the first entries of a level and message logged in a tick, 0 means 100.
+	First int
+	// Thereafter every Thereafter-th entry after First is logged, 0 means 100,
+	// a negative one means none.
+	Thereafter int
+	// Summary interval of the "sampled out N entries" entry, which is logged by the wrapped
+	// handler if any entry is sampled out in the interval, 0 means 1 minute.
+	Summary time.Duration
+	// Exempt the entries at or above the level are never sampled out, e.g. ERROR,
+	// empty means FATAL.
+	Exempt string
+}
+
+// SamplerHandler caps the entries of every level and message handed to the wrapped handler,
+// it logs the first entries per tick then every Thereafter-th one, so a log storm of a
+// tight loop can't flood the sink, e.g. fill the disk and evict the useful rotated files.
+// The counters are indexed by the hash of the log field, so a few distinct messages may
+// share a counter.
+type SamplerHandler struct {
+	h Handler
+	s *sampler
+}
+
+// samplerCounter counts the entries of a tick.
+type samplerCounter struct {
+	resetAt int64
+	count   uint64
+}
+
+// sampler is the counters shared by a SamplerHandler and its children.
+type sampler struct {
+	tick       int64
+	first      uint64
+	thereafter uint64
+	exempt     Level
+	summary    int64
+	// now returns the current time, it's replaced by the tests.
+	now func() time.Time
+
+	counters [_offLevel][_samplerCounters]samplerCounter
+	// sampled counts the sampled out entries of each level.
+	sampled [_offLevel]uint64
+	// reported is the total of sampled when the last summary is logged.
+	reported uint64
+	// reportAt is the unix nano time of the next summary.
+	reportAt int64
+}
+
+// Sample wraps h into a SamplerHandler, nil conf means the defaults, it panics if conf is invalid.
+func Sample(h Handler, conf *SamplerConfig) *SamplerHandler {
+	handler, err := newSampler(h, conf)
+	if err != nil {
+		panic(err)
+	}
+	return handler
+}
+
+func newSampler(h Handler, conf *SamplerConfig) (*SamplerHandler, error) {
+	c := SamplerConfig{}
+	if conf != nil {
+		c = *conf
+	}
+	if c.Tick <= 0 {
+		c.Tick = _samplerTick
+	}
+	if c.First <= 0 {
+		c.First = _samplerFirst
+	}
+	if c.Thereafter == 0 {
+		c.Thereafter = _samplerThereafter
+	}
+	if c.Thereafter < 0 {
+		c.Thereafter = 0
+	}
+	if c.Summary <= 0 {
+		c.Summary = _samplerSummary
+	}
+	exempt := _fatalLevel
+	if c.Exempt != "" {
+		lv, ok := parseLevel(c.Exempt)
+		if !ok {
+			return nil, fmt.Errorf("log: invalid sampler exempt level %q", c.Exempt)
+		}
+		exempt = lv
+	}
+	s := &sampler{
+		tick:       int64(c.Tick),
+		first:      uint64(c.First),
+		thereafter: uint64(c.Thereafter),
+		exempt:     exempt,
+		summary:    int64(c.Summary),
+		now:        time.Now,
+	}
+	s.reportAt = s.now().UnixNano() + s.summary
+	return &SamplerHandler{h: h, s: s}, nil
+}
+
+// Log hands the entry to the wrapped handler unless it's sampled out.
+func (h *SamplerHandler) Log(ctx context.Context, lv Level, args ...D) {
+	now := h.s.now().UnixNano()
+	if !h.s.sample(lv, args, now) {
+		atomic.AddUint64(&h.s.sampled[samplerLevel(lv)], 1)
+		h.s.tryReport(h.h, now)
+		return
+	}
+	h.h.Log(ctx, lv, args...)
+	h.s.tryReport(h.h, now)
+}
+
+// With returns a sampler handler shares the counters and attaches fields to every entry.
+func (h *SamplerHandler) With(fields ...D) Handler {
+	return &SamplerHandler{h: withFields(h.h, fields), s: h.s}
+}
+
+// Enabled reports whether the wrapped handler is enabled for lv.
+func (h *SamplerHandler) Enabled(lv Level) bool {
+	return handlerEnabled(h.h, lv)
+}
+
+// Sampled returns the number of the sampled out entries.
+func (h *SamplerHandler) Sampled() uint64 {
+	return h.s.total()
+}
+
+// Close logs the summary of the sampled out entries then closes the wrapped handler.
+func (h *SamplerHandler) Close() error {
+	h.s.report(h.h)
+	return h.h.Close()
+}
+
+// SetFormat set the format of the wrapped handler.
+func (h *SamplerHandler) SetFormat(format string) {
+	h.h.SetFormat(format)
+}
+
+// sample reports whether the entry should be logged, the exempt levels are always logged.
+func (s *sampler) sample(lv Level, args []D, now int64) bool {
+	if lv >= s.exempt || lv >= _fatalLevel {
+		return true
+	}
+	var msg string
+	for i := range args {
+		if args[i].Key == _log {
+			msg = args[i].StringVal
+			break
+		}
+	}
+	c := &s.counters[samplerLevel(lv)][fnv32a(msg)%_samplerCounters]
+	n := c.inc(now, s.tick)
+	if n <= s.first {
+		return true
+	}
+	return s.thereafter != 0 && (n-s.first)%s.thereafter == 0
+}
+
+// inc increases the counter and returns the count of the tick, the counter is reset
+// if the tick is over.
+func (c *samplerCounter) inc(now, tick int64) uint64 {
+	resetAt := atomic.LoadInt64(&c.resetAt)
+	if resetAt > now {
+		return atomic.AddUint64(&c.count, 1)
+	}
+	atomic.StoreUint64(&c.count, 1)
+	if !atomic.CompareAndSwapInt64(&c.resetAt, resetAt, now+tick) {
+		// another goroutine reset it.
+		return atomic.AddUint64(&c.count, 1)
+	}
+	return 1
+}
+
+func (s *sampler) total() (n uint64) {
+	for i := range s.sampled {
+		n += atomic.LoadUint64(&s.sampled[i])
+	}
+	return
+}
+
+// tryReport logs the summary if it's time, only one goroutine reports at a time.
+func (s *sampler) tryReport(h Handler, now int64) {
+	reportAt := atomic.LoadInt64(&s.reportAt)
+	if now < reportAt || !atomic.CompareAndSwapInt64(&s.reportAt, reportAt, now+s.summary) {
+		return
+	}
+	s.report(h)
+}
+
+// report logs a summary of the entries sampled out since the last one.
+func (s *sampler) report(h Handler) {
+	total := s.total()
+	reported := atomic.LoadUint64(&s.reported)
+	if total == reported || !atomic.CompareAndSwapUint64(&s.reported, reported, total) {
+		return
+	}
+	n := total - reported
+	if handlerEnabled(h, _warnLevel) {
+		h.Log(context.Background(), _warnLevel, summaryEntry("log: sampled out "+strconv.FormatUint(n, 10)+" entries",
+			KVUint64("sampled", n), KVUint64("sampled_total", total))...)
+	}
+}
+
+func samplerLevel(lv Level) Level {
+	if lv < _debugLevel {
+		return _debugLevel
+	}
+	if lv >= _offLevel {
+		return _fatalLevel
+	}
+	return lv
+}
+
+// fnv32a returns the FNV-1a hash of s without allocation.
+func fnv32a(s string) uint32 {
+	const (
+		offset32 = 2166136261
+		prime32  = 16777619
+	)
+	hash := uint32(offset32)
+	for i := 0; i < len(s); i++ {
+		hash ^= uint32(s[i])
+		hash *= prime32
+	}
+	return hash
+}
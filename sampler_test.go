@@ -0,0 +1,132 @@
+package log
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock is a clock advanced by the tests.
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{t: time.Unix(1600000000, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) Add(d time.Duration) {
+	c.mu.Lock()
+	c.t = c.t.Add(d)
+	c.mu.Unlock()
+}
+
+// sampleClock wraps h into a SamplerHandler reads the time from clock.
+func sampleClock(h Handler, conf *SamplerConfig, clock *fakeClock) *SamplerHandler {
+	sh := Sample(h, conf)
+	sh.s.now = clock.Now
+	sh.s.reportAt = clock.Now().UnixNano() + sh.s.summary
+	return sh
+}
+
+func TestSampler(t *testing.T) {
+	th := &testHandler{}
+	sh := sampleClock(th, &SamplerConfig{First: 2, Thereafter: 3}, newFakeClock())
+	l := newLogger(&Config{}, newHandlers(nil, sh))
+	for i := 0; i < 10; i++ {
+		l.Error("storm")
+	}
+	l.Warn("storm")
+	l.With(KVInt("uid", 1)).Error("other")
+	// storm #1, #2, #5, #8 of ERROR, the WARN and the other message.
+	assert.Len(t, th.entries, 6)
+	assert.Equal(t, uint64(6), sh.Sampled())
+
+	assert.NoError(t, l.Close())
+	if assert.Len(t, th.entries, 7) {
+		summary := th.entries[6]
+		assert.Equal(t, _warnLevel, summary.lv)
+		assert.Equal(t, "log: sampled out 6 entries", summary.fields[_log])
+	}
+
+	th = &testHandler{}
+	sh = sampleClock(th, &SamplerConfig{First: 1, Thereafter: -1}, newFakeClock())
+	for i := 0; i < 3; i++ {
+		sh.Log(context.Background(), _infoLevel, KVString(_log, "once"))
+	}
+	assert.Len(t, th.entries, 1)
+	assert.Equal(t, uint64(2), sh.Sampled())
+}
+
+func TestSamplerTick(t *testing.T) {
+	th := &testHandler{}
+	clock := newFakeClock()
+	sh := sampleClock(th, &SamplerConfig{Tick: time.Second, First: 1, Thereafter: -1, Summary: time.Minute}, clock)
+	storm := func() { sh.Log(context.Background(), _infoLevel, KVString(_log, "storm")) }
+	storm()
+	storm()
+	assert.Len(t, th.entries, 1)
+
+	// the counter is reset by the next tick.
+	clock.Add(time.Second)
+	storm()
+	storm()
+	assert.Len(t, th.entries, 2)
+	assert.Equal(t, uint64(2), sh.Sampled())
+
+	// the summary is logged by the first entry after the interval.
+	clock.Add(time.Minute)
+	storm()
+	if assert.Len(t, th.entries, 4) {
+		assert.Equal(t, "storm", th.entries[2].fields[_log])
+		assert.Equal(t, "log: sampled out 2 entries", th.entries[3].fields[_log])
+	}
+	storm()
+	clock.Add(time.Minute)
+	storm()
+	if assert.Len(t, th.entries, 6) {
+		assert.Equal(t, "log: sampled out 1 entries", th.entries[5].fields[_log])
+		assert.Equal(t, int64(3), th.entries[5].fields["sampled_total"])
+	}
+}
+
+func TestSamplerAlloc(t *testing.T) {
+	sh := Sample(&testHandler{}, &SamplerConfig{First: 1, Thereafter: -1})
+	args := []D{KVString(_log, "storm")}
+	sh.Log(context.Background(), _infoLevel, args...)
+	allocs := testing.AllocsPerRun(100, func() {
+		sh.s.sample(_infoLevel, args, time.Now().UnixNano())
+	})
+	assert.Equal(t, float64(0), allocs)
+}
+
+func TestSamplerExempt(t *testing.T) {
+	th := &testHandler{}
+	sh := sampleClock(th, &SamplerConfig{First: 1, Thereafter: -1}, newFakeClock())
+	for i := 0; i < 3; i++ {
+		sh.Log(context.Background(), _fatalLevel, KVString(_log, "fatal"))
+	}
+	assert.Len(t, th.entries, 3)
+
+	th = &testHandler{}
+	sh = sampleClock(th, &SamplerConfig{First: 1, Thereafter: -1, Exempt: "ERROR"}, newFakeClock())
+	for i := 0; i < 3; i++ {
+		sh.Log(context.Background(), _errorLevel, KVString(_log, "error"))
+		sh.Log(context.Background(), _warnLevel, KVString(_log, "warn"))
+	}
+	assert.Len(t, th.entries, 4)
+	assert.Equal(t, uint64(2), sh.Sampled())
+
+	_, err := newSampler(th, &SamplerConfig{Exempt: "LOUD"})
+	assert.Error(t, err)
+}
@@ -1,53 +1,32 @@
 package log
 
 import (
-	"context"
+	"io"
 	"os"
-	"time"
 )
 
 const defaultPattern = "%L %d-%T %F %M"
 
 var _defaultStdout = NewStdout()
 
-// StdoutHandler stdout log handler
+// StdoutHandler console log handler, it's a WriterHandler writes to os.Stderr by default,
+// see NewStdoutWriter and Config.StdoutWriter for os.Stdout.
 type StdoutHandler struct {
-	render Render
+	*WriterHandler
 }
 
-// NewStdout create a stdout log handler
+// NewStdout create a stdout log handler writes to os.Stderr handles all levels, see SetLevel.
 func NewStdout() *StdoutHandler {
-	return &StdoutHandler{render: newPatternRender(defaultPattern)}
+	return NewStdoutWriter(os.Stderr)
 }
 
-// Log stdout logging, only for developing env.
-func (h *StdoutHandler) Log(ctx context.Context, lv Level, args ...D) {
-	d := toMap(args...)
-	// add extra fields
-	addExtraField(ctx, d)
-	d[_time] = time.Now().Format(_timeFormat)
-	h.render.Render(os.Stderr, d)
-	os.Stderr.Write([]byte("\n"))
+// NewStdoutWriter create a stdout log handler writes to w handles all levels, w is usually
+// os.Stdout or os.Stderr.
+func NewStdoutWriter(w io.Writer) *StdoutHandler {
+	return &StdoutHandler{NewWriterHandler(w, defaultPattern)}
 }
 
-// Close stdout loging
-func (h *StdoutHandler) Close() error {
-	return nil
-}
-
-// SetFormat set stdout log output format
-// %T time format at "15:04:05.999"
-// %t time format at "15:04:05"
-// %D data format at "2006/01/02"
-// %d data format at "01/02"
-// %L log level e.g. INFO WARN ERROR
-// %f function name and line number e.g. model.Get:121
-// %i instance id
-// %e deploy env e.g. dev uat fat prod
-// %z zone
-// %S full file name and line number: /a/b/c/d.go:23
-// %s final file name element and line number: d.go:23
-// %M log message and additional fields: key=value this is log message
-func (h *StdoutHandler) SetFormat(format string) {
-	h.render = newPatternRender(format)
+// With returns a stdout handler attaches fields to every entry.
+func (h *StdoutHandler) With(fields ...D) Handler {
+	return &StdoutHandler{h.with(fields)}
 }
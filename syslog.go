@@ -0,0 +1,332 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hxchjm/log/core"
+)
+
+// message formats of SyslogConfig.
+const (
+	SyslogRFC5424 = "rfc5424"
+	SyslogRFC3164 = "rfc3164"
+)
+
+const (
+	_syslogTimeout = time.Second
+	// _facilityUser is the user-level messages facility.
+	_facilityUser = 1
+	// _syslogSDID is the SD-ID of the structured data holds the fields.
+	_syslogSDID = "fields@32473"
+)
+
+// _localSyslog is the sockets of the local syslog daemon.
+var _localSyslog = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// SyslogConfig syslog config, see NewSyslog.
+type SyslogConfig struct {
+	// Network of the syslog server, one of udp, tcp, unix and unixgram,
+	// empty means the local daemon at /dev/log.
+	Network string
+	// Addr host:port or socket path of the syslog server.
+	Addr string
+	// Format of the messages, rfc5424 or rfc3164, empty means rfc5424.
+	Format string
+	// Facility 0-23, e.g. 16 for local0, 0 means 1 user-level messages.
+	Facility int
+	// Timeout of dialing and writing.
+	Timeout time.Duration
+}
+
+// SyslogHandler sends entries to a syslog server, the app-name and hostname are
+// Config.Family and Config.Host, the fields are the RFC 5424 structured data.
+// The entries are written to stderr while the server is down.
+type SyslogHandler struct {
+	fields []D
+	level  *atomicLevel
+	c      *SyslogConfig
+	nc     *netConn
+}
+
+// NewSyslog create a syslog handler handles all levels, it panics if the config is invalid,
+// the server is dialed on the first entry.
+func NewSyslog(sc *SyslogConfig) *SyslogHandler {
+	handler, err := newSyslog(sc)
+	if err != nil {
+		panic(err)
+	}
+	return handler
+}
+
+func newSyslog(sc *SyslogConfig) (*SyslogHandler, error) {
+	c := SyslogConfig{}
+	if sc != nil {
+		c = *sc
+	}
+	switch c.Network {
+	case "":
+	case "udp", "udp4", "udp6", "tcp", "tcp4", "tcp6", "unix", "unixgram":
+		if c.Addr == "" {
+			return nil, fmt.Errorf("log: empty syslog address of network %q", c.Network)
+		}
+	default:
+		return nil, fmt.Errorf("log: unknown syslog network %q", c.Network)
+	}
+	switch c.Format {
+	case "":
+		c.Format = SyslogRFC5424
+	case SyslogRFC5424, SyslogRFC3164:
+	default:
+		return nil, fmt.Errorf("log: unknown syslog format %q", c.Format)
+	}
+	if c.Facility < 0 || c.Facility > 23 {
+		return nil, fmt.Errorf("log: invalid syslog facility %d", c.Facility)
+	}
+	if c.Facility == 0 {
+		c.Facility = _facilityUser
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = _syslogTimeout
+	}
+	return &SyslogHandler{
+		level: newAtomicLevel(_debugLevel),
+		c:     &c,
+		nc:    newNetConn("log-syslog ", c.Timeout, c.dial),
+	}, nil
+}
+
+// Log sends the entry to the syslog server.
+func (h *SyslogHandler) Log(ctx context.Context, lv Level, args ...D) {
+	buf := core.GetPool()
+	if h.c.Format == SyslogRFC3164 {
+		h.rfc3164(buf, lv, args)
+	} else {
+		h.rfc5424(buf, lv, args)
+	}
+	h.nc.write(buf.Bytes(), h.frame)
+	buf.Free()
+}
+
+// With returns a syslog handler shares the connection and attaches fields to every entry.
+func (h *SyslogHandler) With(fields ...D) Handler {
+	bound := append(h.fields[:len(h.fields):len(h.fields)], fields...)
+	return &SyslogHandler{fields: bound, level: h.level, c: h.c, nc: h.nc}
+}
+
+// SetLevel set the minimum level of syslog handler.
+func (h *SyslogHandler) SetLevel(lv Level) {
+	h.level.SetLevel(lv)
+}
+
+// Enabled reports whether lv is at or above the minimum level.
+func (h *SyslogHandler) Enabled(lv Level) bool {
+	return h.level.Enabled(lv)
+}
+
+// Close closes the connection.
+func (h *SyslogHandler) Close() error {
+	return h.nc.close()
+}
+
+// SetFormat is ignored, the message format is SyslogConfig.Format.
+func (h *SyslogHandler) SetFormat(string) {}
+
+// syslogSeverity maps lv to the syslog severity.
+func syslogSeverity(lv Level) int {
+	switch lv {
+	case _debugLevel:
+		return 7 // debug
+	case _infoLevel:
+		return 6 // informational
+	case _warnLevel:
+		return 4 // warning
+	case _errorLevel:
+		return 3 // error
+	default:
+		return 2 // critical
+	}
+}
+
+// syslogEntry is the parts of an entry in the syslog message.
+type syslogEntry struct {
+	time   time.Time
+	host   string
+	app    string
+	msg    string
+	fields []D
+}
+
+func (h *SyslogHandler) entry(args []D) *syslogEntry {
+	e := &syslogEntry{}
+	for _, f := range args {
+		switch f.Key {
+		case _time:
+			e.time, _ = f.Value.(time.Time)
+		case _instanceID:
+			e.host = f.StringVal
+		case _appID:
+			e.app = f.StringVal
+		case _log:
+			e.msg = f.StringVal
+		default:
+			if !isInternalKey(f.Key) {
+				e.fields = append(e.fields, f)
+			}
+		}
+	}
+	if e.time.IsZero() {
+		e.time = time.Now()
+	}
+	// the fields of the entry take precedence over the bound ones.
+	n := len(e.fields)
+	for _, f := range h.fields {
+		if isInternalKey(f.Key) || f.Key == _log || hasKey(e.fields[:n], f.Key) {
+			continue
+		}
+		e.fields = append(e.fields, f)
+	}
+	return e
+}
+
+// rfc5424 formats the entry as <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID [SD] MSG.
+func (h *SyslogHandler) rfc5424(buf *core.Buffer, lv Level, args []D) {
+	e := h.entry(args)
+	buf.AppendByte('<')
+	buf.AppendInt(int64(h.c.Facility*8 + syslogSeverity(lv)))
+	buf.AppendString(">1 ")
+	buf.AppendString(e.time.Format("2006-01-02T15:04:05.000000Z07:00"))
+	buf.AppendByte(' ')
+	buf.AppendString(syslogName(e.host, 255))
+	buf.AppendByte(' ')
+	buf.AppendString(syslogName(e.app, 48))
+	buf.AppendByte(' ')
+	buf.AppendInt(int64(os.Getpid()))
+	buf.AppendString(" - ")
+	if len(e.fields) == 0 {
+		buf.AppendByte('-')
+	} else {
+		buf.AppendString("[" + _syslogSDID)
+		for _, f := range e.fields {
+			buf.AppendByte(' ')
+			buf.AppendString(sdName(f.Key))
+			buf.AppendString(`="`)
+			sdValue(buf, fieldString(f))
+			buf.AppendByte('"')
+		}
+		buf.AppendByte(']')
+	}
+	if e.msg != "" {
+		buf.AppendByte(' ')
+		buf.AppendString(e.msg)
+	}
+}
+
+// rfc3164 formats the entry as <PRI>Mmm dd hh:mm:ss HOSTNAME TAG[PID]: MSG key=value.
+func (h *SyslogHandler) rfc3164(buf *core.Buffer, lv Level, args []D) {
+	e := h.entry(args)
+	buf.AppendByte('<')
+	buf.AppendInt(int64(h.c.Facility*8 + syslogSeverity(lv)))
+	buf.AppendByte('>')
+	buf.AppendString(e.time.Format(time.Stamp))
+	buf.AppendByte(' ')
+	buf.AppendString(syslogName(e.host, 255))
+	buf.AppendByte(' ')
+	buf.AppendString(syslogName(e.app, 32))
+	buf.AppendByte('[')
+	buf.AppendInt(int64(os.Getpid()))
+	buf.AppendString("]: ")
+	buf.AppendString(e.msg)
+	for _, f := range e.fields {
+		buf.AppendByte(' ')
+		buf.AppendString(f.Key)
+		buf.AppendByte('=')
+		buf.AppendString(fieldString(f))
+	}
+}
+
+func hasKey(fields []D, key string) bool {
+	for _, f := range fields {
+		if f.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// syslogName returns s of printable ASCII without spaces and at most max bytes, "-" if empty.
+func syslogName(s string, max int) string {
+	if s == "" {
+		return "-"
+	}
+	if len(s) > max {
+		s = s[:max]
+	}
+	return strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// sdName returns key as an SD-NAME, which is at most 32 printable ASCII except '=', ' ', ']' and '"'.
+func sdName(key string) string {
+	if len(key) > 32 {
+		key = key[:32]
+	}
+	return strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 || r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, key)
+}
+
+// sdValue appends s as a PARAM-VALUE, '"', '\' and ']' are escaped.
+func sdValue(buf *core.Buffer, s string) {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\', ']':
+			buf.AppendByte('\\')
+			buf.AppendByte(c)
+		default:
+			buf.AppendByte(c)
+		}
+	}
+}
+
+// frame writes msg with the octet-counting framing of RFC 6587 on tcp connections, and
+// newline delimited on unix stream sockets, which the local daemons read by lines.
+func (h *SyslogHandler) frame(conn net.Conn, msg []byte) error {
+	switch {
+	case !isStream(conn):
+	case conn.RemoteAddr().Network() == "unix":
+		msg = append(msg[:len(msg):len(msg)], '\n')
+	default:
+		b := strconv.AppendInt(make([]byte, 0, len(msg)+8), int64(len(msg)), 10)
+		msg = append(append(b, ' '), msg...)
+	}
+	_, err := conn.Write(msg)
+	return err
+}
+
+// dial dials the server, or the local daemon if no network.
+func (c *SyslogConfig) dial() (conn net.Conn, err error) {
+	if c.Network != "" {
+		return net.DialTimeout(c.Network, c.Addr, c.Timeout)
+	}
+	for _, network := range []string{"unixgram", "unix"} {
+		for _, path := range _localSyslog {
+			if conn, err = net.DialTimeout(network, path, c.Timeout); err == nil {
+				return conn, nil
+			}
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,166 @@
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyslogUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer pc.Close()
+	sh, err := newSyslog(&SyslogConfig{Network: "udp", Addr: pc.LocalAddr().String(), Facility: 16})
+	if !assert.NoError(t, err) {
+		return
+	}
+	l := newLogger(&Config{Family: "main.app", Host: "host-1"}, newHandlers(nil, sh))
+	l.With(KVString("order_id", `o"1]`)).Warnv(context.Background(), KVString(_log, "paid"), KVInt("uid", 1))
+	defer l.Close()
+
+	pc.SetReadDeadline(time.Now().Add(time.Second))
+	b := make([]byte, 2048)
+	n, _, err := pc.ReadFrom(b)
+	if !assert.NoError(t, err) {
+		return
+	}
+	re := regexp.MustCompile(`^<132>1 \d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{6}\S+ host-1 main\.app \d+ - \[fields@32473 (.*)\] paid$`)
+	m := re.FindStringSubmatch(string(b[:n]))
+	if assert.NotNil(t, m, string(b[:n])) {
+		assert.Equal(t, `uid="1" order_id="o\"1\]"`, m[1])
+	}
+}
+
+func TestSyslogTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer ln.Close()
+	msgs := make(chan string, 4)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			size, err := r.ReadString(' ')
+			if err != nil {
+				return
+			}
+			n, _ := strconv.Atoi(strings.TrimSpace(size))
+			b := make([]byte, n)
+			if _, err = io.ReadFull(r, b); err != nil {
+				return
+			}
+			msgs <- string(b)
+		}
+	}()
+	sh, err := newSyslog(&SyslogConfig{Network: "tcp", Addr: ln.Addr().String(), Format: SyslogRFC3164})
+	if !assert.NoError(t, err) {
+		return
+	}
+	l := newLogger(&Config{Family: "app", Host: "h"}, newHandlers(nil, sh))
+	defer l.Close()
+	l.Error("first")
+	l.Info("second")
+	assert.Regexp(t, `^<11>\w{3} [ \d]\d \d\d:\d\d:\d\d h app\[\d+\]: first$`, <-msgs)
+	assert.Regexp(t, `^<14>.* app\[\d+\]: second$`, <-msgs)
+}
+
+func TestSyslogUnixStream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log-syslog")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	addr := filepath.Join(dir, "syslog.sock")
+	ln, err := net.Listen("unix", addr)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer ln.Close()
+	msgs := make(chan string, 4)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			msgs <- line
+		}
+	}()
+	sh, err := newSyslog(&SyslogConfig{Network: "unix", Addr: addr, Format: SyslogRFC3164})
+	if !assert.NoError(t, err) {
+		return
+	}
+	l := newLogger(&Config{Family: "app", Host: "h"}, newHandlers(nil, sh))
+	defer l.Close()
+	l.Warn("first")
+	l.Info("second")
+	assert.Regexp(t, `^<12>.* app\[\d+\]: first\n$`, <-msgs)
+	assert.Regexp(t, `^<14>.* app\[\d+\]: second\n$`, <-msgs)
+}
+
+func TestSyslogReconnect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log-syslog")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	addr := filepath.Join(dir, "syslog.sock")
+	sh, err := newSyslog(&SyslogConfig{Network: "unixgram", Addr: addr})
+	if !assert.NoError(t, err) {
+		return
+	}
+	fallback := &bytes.Buffer{}
+	clock := newFakeClock()
+	sh.nc.fallback = fallback
+	sh.nc.now = clock.Now
+	l := newLogger(&Config{Family: "app"}, newHandlers(nil, sh))
+	defer l.Close()
+	l.Info("down")
+	assert.Contains(t, fallback.String(), "down")
+
+	pc, err := net.ListenPacket("unixgram", addr)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer pc.Close()
+	clock.Add(_agentBackoffMin)
+	l.Info("up")
+	pc.SetReadDeadline(time.Now().Add(time.Second))
+	b := make([]byte, 2048)
+	n, _, err := pc.ReadFrom(b)
+	if assert.NoError(t, err) {
+		assert.True(t, strings.HasSuffix(string(b[:n]), " - - up"), string(b[:n]))
+	}
+	assert.NotContains(t, fallback.String(), "up")
+
+	_, err = newSyslog(&SyslogConfig{Network: "udp"})
+	assert.Error(t, err)
+	_, err = newSyslog(&SyslogConfig{Format: "cee"})
+	assert.Error(t, err)
+}
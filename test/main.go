@@ -10,7 +10,7 @@ func main() {
 	flag.Parse()
 	log.Init(nil) //需要文件输出，则log.Init必不可少，否则是输出到窗口
 	defer log.Close()
-	ctx := context.WithValue(context.Background(), "trace_id", "1234-5678-9986-4324")
+	ctx := log.NewTraceContext(context.Background(), "1234-5678-9986-4324")
 	log.SetFormat("%L %D %T %i %a %S %F %M")
 	a := 100
 	log.Info(ctx, "11111 %v xxxxxx", a)
@@ -1,51 +1,14 @@
 package log
 
 import (
-	"context"
 	"math"
 	"runtime"
 	"strconv"
 	"time"
 
 	"github.com/hxchjm/log/core"
-	//"go-common/library/net/metadata"
-	//"go-common/library/net/trace"
 )
 
-const _ctxkey = "trace_id"
-
-func FromContext(ctx context.Context) (t string, ok bool) {
-	t, ok = ctx.Value(_ctxkey).(string)
-	return
-}
-
-func addExtraField(ctx context.Context, fields map[string]interface{}) {
-	if t, ok := FromContext(ctx); ok {
-		fields[_tid] = t
-		//fields[_span] = t.SpanID()
-		//fields[_traceSampled] = t.IsSampled()
-	}
-	//if caller := metadata.String(ctx, metadata.Caller); caller != "" {
-	//	fields[_caller] = caller
-	//}
-	//if color := metadata.String(ctx, metadata.Color); color != "" {
-	//	fields[_color] = color
-	//}
-	//if env.Color != "" {
-	//	fields[_envColor] = env.Color
-	//}
-	//if cluster := metadata.String(ctx, metadata.Cluster); cluster != "" {
-	//	fields[_cluster] = cluster
-	//}
-	//fields[_deplyEnv] = env.DeployEnv
-	//fields[_zone] = env.Zone
-	fields[_appID] = c.Family
-	fields[_instanceID] = c.Host
-	//if metadata.String(ctx, metadata.Mirror) != "" {
-	//	fields[_mirror] = true
-	//}
-}
-
 // funcName get func name.
 func funcName(skip int) (name string) {
 	if _, file, lineNo, ok := runtime.Caller(skip); ok {
@@ -54,6 +17,34 @@ func funcName(skip int) (name string) {
 	return "unknown:0"
 }
 
+// stack returns the stack traces of all goroutines.
+func stack() string {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= 8<<20 {
+			return string(buf[:n])
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// bindFields copies the fields bound by Logger.With into d, the fields of the entry win.
+func bindFields(d, fields map[string]interface{}) {
+	for k, v := range fields {
+		if _, ok := d[k]; !ok {
+			d[k] = v
+		}
+	}
+}
+
+// mergeFields returns a new map contains base and fields.
+func mergeFields(base map[string]interface{}, fields []D) map[string]interface{} {
+	d := toMap(fields...)
+	bindFields(d, base)
+	return d
+}
+
 // toMap convert D slice to map[string]interface{} for legacy file and stdout.
 func toMap(args ...D) map[string]interface{} {
 	d := make(map[string]interface{}, 10+len(args))
@@ -69,6 +60,8 @@ func toMap(args ...D) map[string]interface{} {
 			d[arg.Key] = math.Float64frombits(uint64(arg.Int64Val))
 		case core.DurationType:
 			d[arg.Key] = time.Duration(arg.Int64Val)
+		case core.BoolType:
+			d[arg.Key] = arg.StringVal == "true"
 		default:
 			d[arg.Key] = arg.Value
 		}
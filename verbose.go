@@ -0,0 +1,166 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// _noModule marks a call site whose file matches no module pattern.
+const _noModule = math.MinInt32
+
+// verboseModule is a flag.Value of per-file V levels, format: file=1,file2=2.
+type verboseModule map[string]int32
+
+func (m verboseModule) String() string {
+	// FIXME strings.Builder
+	var buf bytes.Buffer
+	for k, v := range m {
+		buf.WriteString(k)
+		buf.WriteString("=")
+		buf.WriteString(strconv.FormatInt(int64(v), 10))
+		buf.WriteString(",")
+	}
+	return strings.TrimSuffix(buf.String(), ",")
+}
+
+// Set sets the value of the named command-line flag.
+// format: -log.module file=1,file2=2
+func (m verboseModule) Set(value string) error {
+	for _, i := range strings.Split(value, ",") {
+		kv := strings.Split(i, "=")
+		if len(kv) != 2 {
+			return fmt.Errorf("log: invalid module %q, format: file=1,file2=2", i)
+		}
+		v, err := strconv.ParseInt(strings.TrimSpace(kv[1]), 10, 32)
+		if err != nil {
+			return fmt.Errorf("log: invalid module %q: %v", i, err)
+		}
+		m[strings.TrimSpace(kv[0])] = int32(v)
+	}
+	return nil
+}
+
+// moduleCache resolves the module V level of a call site and caches it by pc,
+// so that only the first call from each site pays for the pattern matching.
+type moduleCache struct {
+	module map[string]int32
+
+	mu     sync.Mutex
+	levels atomic.Value // map[uintptr]int32, copy on write
+}
+
+func newModuleCache(module map[string]int32) *moduleCache {
+	mc := &moduleCache{module: module}
+	mc.levels.Store(map[uintptr]int32{})
+	return mc
+}
+
+// level returns the V level of the call site pc, or _noModule if no pattern matched.
+func (mc *moduleCache) level(pc uintptr) int32 {
+	if lv, ok := mc.levels.Load().(map[uintptr]int32)[pc]; ok {
+		return lv
+	}
+	frame, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+	lv := mc.match(strings.TrimSuffix(filepath.Base(frame.File), ".go"))
+
+	mc.mu.Lock()
+	old := mc.levels.Load().(map[uintptr]int32)
+	levels := make(map[uintptr]int32, len(old)+1)
+	for k, v := range old {
+		levels[k] = v
+	}
+	levels[pc] = lv
+	mc.levels.Store(levels)
+	mc.mu.Unlock()
+	return lv
+}
+
+// match returns the level of the literal file name if present,
+// otherwise the highest level of all matching glob patterns.
+func (mc *moduleCache) match(file string) int32 {
+	if lv, ok := mc.module[file]; ok {
+		return lv
+	}
+	lv := int32(_noModule)
+	for pattern, v := range mc.module {
+		if ok, _ := filepath.Match(pattern, file); ok && v > lv {
+			lv = v
+		}
+	}
+	return lv
+}
+
+// V reports whether verbosity at the call site is at least the requested level.
+// The returned value is a Verbose, which implements Info, Infof, Infov and Infow.
+// These methods will write to the Info log if called.
+// Thus, one may write either
+//
+//	if log.V(2) { log.Info("log this") }
+//
+// or
+//
+//	log.V(2).Info("log this")
+//
+// The second form is shorter but the first is cheaper if logging is off because it does
+// not evaluate its arguments.
+//
+// Whether an individual call to V generates a log record depends on the setting of
+// the Config.V or the -log.v flag, defaults to 0. Config.Module or the -log.module flag
+// raise the level for the matching source files. Both can be changed at runtime,
+// see Logger.SetV and Logger.SetModule.
+func V(v int32) Verbose {
+	ls := std().levels
+	if v <= ls.V() {
+		return true
+	}
+	vmodule := ls.vmodule.Load().(*moduleCache)
+	if len(vmodule.module) == 0 {
+		return false
+	}
+	var pcs [1]uintptr
+	if runtime.Callers(2, pcs[:]) == 0 {
+		return false
+	}
+	return Verbose(v <= vmodule.level(pcs[0]))
+}
+
+// Info logs a message at the info log level, it does nothing if the verbose is disabled.
+func (v Verbose) Info(args ...interface{}) {
+	if !v {
+		return
+	}
+	std().print(_infoLevel, args)
+}
+
+// Infof logs a message at the info log level, it does nothing if the verbose is disabled.
+func (v Verbose) Infof(args ...interface{}) {
+	if !v {
+		return
+	}
+	std().printf(_infoLevel, args)
+}
+
+// Infov logs a message at the info log level, it does nothing if the verbose is disabled.
+func (v Verbose) Infov(ctx context.Context, args ...D) {
+	if !v {
+		return
+	}
+	std().printv(ctx, _infoLevel, args)
+}
+
+// Infow logs a message with some additional context, it does nothing if the verbose is disabled.
+// The variadic key-value pairs are treated as they are in With.
+func (v Verbose) Infow(ctx context.Context, args ...interface{}) {
+	if !v {
+		return
+	}
+	std().printw(ctx, _infoLevel, args)
+}
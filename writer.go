@@ -0,0 +1,104 @@
+package log
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hxchjm/log/core"
+)
+
+// WriterHandler writes entries to an io.Writer, e.g. os.Stdout, a pipe or a network connection,
+// every entry goes out in one Write call and the calls are serialized, so the entries of
+// concurrent goroutines never interleave even if w isn't safe for concurrent use.
+type WriterHandler struct {
+	// enc holds a boxedEncoder, it's replaced by SetFormat while other goroutines are logging.
+	enc    atomic.Value
+	fields []D
+	level  *atomicLevel
+
+	// mu guards w, it's shared by the handler and its children.
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterHandler create a handler writes to w handles all levels, format is a pattern
+// see SetFormat or "json" for JSON lines.
+func NewWriterHandler(w io.Writer, format string) *WriterHandler {
+	return newWriterHandler(w, newEntryEncoder(format, nil))
+}
+
+func newWriterHandler(w io.Writer, enc entryEncoder) *WriterHandler {
+	h := &WriterHandler{level: newAtomicLevel(_debugLevel), mu: &sync.Mutex{}, w: w}
+	h.enc.Store(boxedEncoder{enc})
+	return h
+}
+
+// boxedEncoder boxes an entryEncoder, so the encoders of different types can be stored
+// in the same atomic.Value.
+type boxedEncoder struct {
+	entryEncoder
+}
+
+// encoder returns the current encoder.
+func (h *WriterHandler) encoder() entryEncoder {
+	return h.enc.Load().(boxedEncoder).entryEncoder
+}
+
+// Log writes the entry to w.
+func (h *WriterHandler) Log(ctx context.Context, lv Level, args ...D) {
+	buf := core.GetPool()
+	if err := h.encoder().Encode(buf, args); err == nil {
+		h.mu.Lock()
+		h.w.Write(buf.Bytes())
+		h.mu.Unlock()
+	}
+	buf.Free()
+}
+
+// With returns a handler shares w and attaches fields to every entry.
+func (h *WriterHandler) With(fields ...D) Handler {
+	return h.with(fields)
+}
+
+func (h *WriterHandler) with(fields []D) *WriterHandler {
+	bound := append(h.fields[:len(h.fields):len(h.fields)], fields...)
+	child := &WriterHandler{fields: bound, level: h.level, mu: h.mu, w: h.w}
+	child.enc.Store(boxedEncoder{h.encoder().With(fields)})
+	return child
+}
+
+// SetLevel set the minimum level of the handler.
+func (h *WriterHandler) SetLevel(lv Level) {
+	h.level.SetLevel(lv)
+}
+
+// Enabled reports whether lv is at or above the minimum level.
+func (h *WriterHandler) Enabled(lv Level) bool {
+	return h.level.Enabled(lv)
+}
+
+// Close does nothing, w is owned by the caller.
+func (h *WriterHandler) Close() error {
+	return nil
+}
+
+// SetFormat set log output format
+// %T time format at "15:04:05.999"
+// %t time format at "15:04:05"
+// %D data format at "2006/01/02"
+// %d data format at "01/02"
+// %L log level e.g. INFO WARN ERROR
+// %f function name and line number e.g. model.Get:121
+// %i instance id
+// %e deploy env e.g. dev uat fat prod
+// %z zone
+// %S full file name and line number: /a/b/c/d.go:23
+// %s final file name element and line number: d.go:23
+// %M log message and additional fields: key=value this is log message
+// or "json" for JSON lines, see FormatJSON.
+// It's safe to call while other goroutines are logging.
+func (h *WriterHandler) SetFormat(format string) {
+	h.enc.Store(boxedEncoder{newEntryEncoder(format, h.fields)})
+}